@@ -0,0 +1,73 @@
+// Command rotate-keys re-encrypts the secrets of the stored tasks with the primary encryption key.
+//
+// To rotate the keys, add the new key to the encryption keys and make it the primary key,
+// restart the replicas and run this command. The old key can be removed afterwards.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/maacarma/scheduler/config"
+	"github.com/maacarma/scheduler/pkg/db"
+	"github.com/maacarma/scheduler/pkg/encryption"
+	mongodb "github.com/maacarma/scheduler/pkg/services/tasks/store/mongodb"
+	postgres "github.com/maacarma/scheduler/pkg/services/tasks/store/postgres"
+	"github.com/maacarma/scheduler/utils"
+	"go.uber.org/zap"
+)
+
+// rotator re-encrypts the secrets of the tasks, see the task repos.
+type rotator interface {
+	RotateSecrets(ctx context.Context) (int, error)
+}
+
+func main() {
+	config, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := utils.CreateLogger()
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	keyring, err := encryption.FromConfig(config)
+	if err != nil {
+		logger.Fatal("unable to load the encryption keys", zap.Error(err))
+	}
+	if !keyring.Enabled() {
+		logger.Fatal("no encryption keys are configured")
+	}
+
+	dbClients, err := db.Connect(ctx, config)
+	if err != nil {
+		logger.Fatal("unable to connect to the database", zap.Error(err))
+	}
+
+	var repo rotator
+	switch {
+	case dbClients.Pg != nil:
+		repo = postgres.New(dbClients.Pg, keyring)
+	case dbClients.Mongo != nil:
+		repo = mongodb.New(dbClients.Mongo, keyring)
+	}
+
+	rotated, err := repo.RotateSecrets(ctx)
+	dbClients.Close(context.Background())
+
+	// the tasks re-encrypted before a failure stay re-encrypted, the command can be run again
+	logger.Info("re-encrypted the task secrets", zap.Int("tasks", rotated))
+	if err != nil {
+		logger.Fatal("unable to re-encrypt the task secrets", zap.Error(err))
+	}
+}
@@ -5,11 +5,20 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
+	_ "time/tzdata" // embeds the tz database for task timezones
 
 	"github.com/maacarma/scheduler/config"
 	"github.com/maacarma/scheduler/pkg/api"
+	"github.com/maacarma/scheduler/pkg/cluster"
+	"github.com/maacarma/scheduler/pkg/db"
+	"github.com/maacarma/scheduler/pkg/leader"
 	"github.com/maacarma/scheduler/pkg/schedule"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	"github.com/maacarma/scheduler/pkg/tracing"
+	"github.com/maacarma/scheduler/pkg/watcher"
 	"github.com/maacarma/scheduler/utils"
 	"go.uber.org/zap"
 )
@@ -30,16 +39,110 @@ func main() {
 	)
 	defer stop()
 
-	scheduler, err := schedule.New(ctx, config, logger)
+	shutdownTracing, err := tracing.Setup(ctx, config)
 	if err != nil {
-		logger.Fatal("unable to create scheduler", zap.Error(err))
+		logger.Fatal("unable to set up tracing", zap.Error(err))
+	}
+
+	// the database clients are shared by all the components and closed last,
+	// once the executions are drained and the loops release their connections
+	dbClients, err := db.Connect(ctx, config)
+	if err != nil {
+		logger.Fatal("unable to connect to the database", zap.Error(err))
 	}
-	err = scheduler.Start(ctx)
+
+	scheduler, err := schedule.New(ctx, dbClients, config, logger)
 	if err != nil {
-		logger.Fatal("unable to start scheduler", zap.Error(err))
+		logger.Fatal("unable to create scheduler", zap.Error(err))
+	}
+
+	// the background loops outlive the signal until the executions are drained,
+	// so that the leadership and the shard are held meanwhile
+	background, cancelBackground := context.WithCancel(context.WithoutCancel(ctx))
+	var loops sync.WaitGroup
+	goLoop := func(loop func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop()
+		}()
+	}
+
+	// with the leader election, only the leader runs the scheduler
+	// and with the sharding, each replica schedules its shard of the tasks
+	var elector *leader.Elector
+	switch {
+	case config.Leader.Enabled && config.Cluster.Sharding:
+		logger.Fatal("leader election and sharding can't be enabled together")
+
+	// the tasks changed through a replica are scheduled by the leader or the owner of the shard,
+	// which learns of the changes only through the watcher
+	case (config.Leader.Enabled || config.Cluster.Sharding) && !config.Watcher.Enabled:
+		logger.Fatal("leader election and sharding require the watcher to be enabled")
+
+	case config.Leader.Enabled:
+		elector, err = leader.New(ctx, dbClients, config, logger)
+		if err != nil {
+			logger.Fatal("unable to create leader elector", zap.Error(err))
+		}
+		goLoop(func() {
+			elector.Run(background, func() error { return scheduler.Start(background) }, scheduler.Suspend)
+		})
+
+	case config.Cluster.Sharding:
+		members, err := cluster.New(ctx, dbClients, config, logger)
+		if err != nil {
+			logger.Fatal("unable to join the cluster", zap.Error(err))
+		}
+		goLoop(func() {
+			members.Run(background, func(shard models.Shard) {
+				if err := scheduler.Rebalance(background, shard); err != nil {
+					logger.Error("unable to rebalance scheduler", zap.Error(err))
+				}
+			})
+		})
+
+	default:
+		err = scheduler.Start(ctx)
+		if err != nil {
+			logger.Fatal("unable to start scheduler", zap.Error(err))
+		}
+	}
+
+	// applies the task changes made by the other replicas or directly in the database
+	if config.Watcher.Enabled {
+		changes, err := watcher.New(ctx, dbClients, config, logger)
+		if err != nil {
+			logger.Fatal("unable to create task watcher", zap.Error(err))
+		}
+		goLoop(func() { changes.Run(background, scheduler) })
+	}
+
+	apiErr := api.Start(ctx, dbClients, scheduler, elector, logger, config)
+	if apiErr != nil {
+		logger.Error("Cannot start api server", zap.Error(apiErr))
+	}
+
+	if err := scheduler.Stop(context.Background()); err != nil {
+		logger.Error("unable to stop scheduler gracefully", zap.Error(err))
+	}
+	cancelBackground()
+	loops.Wait()
+
+	// flushes the spans of the drained executions
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logger.Error("unable to flush the spans", zap.Error(err))
+	}
+	cancelTracing()
+
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := dbClients.Close(closeCtx); err != nil {
+		logger.Error("unable to close the db connections", zap.Error(err))
 	}
+	cancelClose()
 
-	if err := api.Start(ctx, scheduler, logger, config); err != nil {
-		logger.Fatal("Cannot start api server", zap.Error(err))
+	if apiErr != nil {
+		os.Exit(1)
 	}
 }
@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	signing "github.com/maacarma/scheduler/pkg/signing"
 
 	"github.com/spf13/viper"
 )
@@ -12,6 +16,9 @@ const (
 	DatabaseEnv    = "DATABASE"
 	MongoURLEnv    = "MONGO_URL"
 	PostgresURLEnv = "POSTGRES_URL"
+	// comma separated id:base64 key pairs, Ex: "k2:<key>,k1:<key>"
+	EncryptionKeysEnv       = "ENCRYPTION_KEYS"
+	EncryptionPrimaryKeyEnv = "ENCRYPTION_PRIMARY_KEY"
 )
 
 // Config struct holds the application configuration
@@ -30,6 +37,117 @@ type Config struct {
 			Url string
 		}
 	}
+	Scheduler struct {
+		// Timeout is the default timeout of a task's http request,
+		// used when the task doesn't set its own timeout. zero means no timeout.
+		Timeout time.Duration
+		// MaxReplays caps the missed fire times replayed for a task
+		// with the replay_all misfire policy when the scheduler starts, zero disables the replays.
+		MaxReplays int `mapstructure:"max_replays"`
+		// DrainTimeout is how long the in-flight executions are waited for on shutdown,
+		// the ones still running afterwards are cancelled. defaults to 30 seconds when zero.
+		DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	}
+	Cluster struct {
+		// ID identifies the replica among the replicas, defaults to the hostname and pid.
+		ID string
+		// Sharding partitions the tasks across the live replicas,
+		// each replica schedules only its shard. exclusive with the leader election.
+		Sharding bool
+		// HeartbeatInterval is the interval of the replica's membership heartbeats.
+		HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+		// MemberTTL is how long a replica stays a member without a heartbeat,
+		// it should be longer than the heartbeat interval.
+		MemberTTL time.Duration `mapstructure:"member_ttl"`
+	}
+	Leader struct {
+		// Enabled runs the scheduler only in the elected leader among the replicas.
+		Enabled bool
+		// Lease is how long the leadership lasts without being renewed.
+		Lease time.Duration
+		// RenewInterval is the interval of renewing or campaigning for the leadership,
+		// it should be shorter than the lease.
+		RenewInterval time.Duration `mapstructure:"renew_interval"`
+	}
+	Watcher struct {
+		// Enabled applies the task changes made by the other replicas or directly
+		// in the database to the scheduler. MongoDB requires a replica set for it.
+		Enabled bool
+		// RetryInterval is the delay before watching again after the watch fails,
+		// it doubles on the consecutive failures up to a minute.
+		RetryInterval time.Duration `mapstructure:"retry_interval"`
+	}
+	Tracing struct {
+		// Exporter exports the spans, "otlp" or "stdout". empty disables the tracing.
+		Exporter string
+		// Endpoint is the host:port of the OTLP/HTTP collector,
+		// defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+		Endpoint string
+		// Insecure exports to the collector over plain http.
+		Insecure bool
+		// SampleRatio is the ratio of the traces sampled, zero samples every trace.
+		SampleRatio float64 `mapstructure:"sample_ratio"`
+	}
+	Signing struct {
+		// SignatureHeader and TimestampHeader are the headers of the signed requests,
+		// unless the task sets its own. defaults to X-Scheduler-Signature and X-Scheduler-Timestamp.
+		SignatureHeader string `mapstructure:"signature_header"`
+		TimestampHeader string `mapstructure:"timestamp_header"`
+		// Namespaces are the signing keys of the namespaces, the requests of the tasks
+		// in a namespace are signed with every key unless the task sets its own keys.
+		// the namespace names are matched in lower case.
+		Namespaces map[string][]signing.Key
+	}
+	Encryption struct {
+		// Keys are the base64 encoded 32 bytes keys encrypting the task secrets at rest by id,
+		// the secrets are stored in plain without keys. the key ids are matched in lower case.
+		Keys map[string]string
+		// PrimaryKey is the id of the key encrypting the secrets, the other keys only decrypt.
+		// a new primary key takes over the old secrets once they are re-encrypted by rotate-keys.
+		PrimaryKey string `mapstructure:"primary_key"`
+	}
+	Templates struct {
+		// EnvPrefix is the prefix of the env vars readable by the task templates,
+		// {{env "NAME"}} reads the env var <prefix>NAME. empty disables the env lookups.
+		EnvPrefix string `mapstructure:"env_prefix"`
+		// SecretsDir is the directory of the secret files readable by the task templates,
+		// {{secret "name"}} reads the file <dir>/name. empty disables the secret lookups.
+		SecretsDir string `mapstructure:"secrets_dir"`
+	}
+	// TLS are the tls profiles of the task requests by name, a task refers to one by its tls_profile.
+	// the profile names are matched in lower case.
+	TLS map[string]TLSProfile
+}
+
+// TLSProfile configures the tls of the task requests to the endpoints which use a private CA
+// or require a client certificate. The files are read once, a change requires a restart.
+type TLSProfile struct {
+	// CAFile is a PEM bundle of the CAs verifying the endpoints, instead of the system CAs.
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the PEM client certificate and key presented to the endpoints.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// MinVersion is the minimum tls version, "1.0", "1.1", "1.2" or "1.3". defaults to 1.2.
+	MinVersion string `mapstructure:"min_version"`
+	// ServerName overrides the name the certificates of the endpoints are verified against,
+	// defaults to the host of the task url.
+	ServerName string `mapstructure:"server_name"`
+}
+
+// TLSProfile returns the tls profile of the given name.
+func (c *Config) TLSProfile(name string) (TLSProfile, bool) {
+	profile, ok := c.TLS[strings.ToLower(name)]
+	return profile, ok
+}
+
+// ReplicaID returns the id of the replica, the hostname and pid unless configured.
+func (c *Config) ReplicaID() string {
+	if c.Cluster.ID != "" {
+		return c.Cluster.ID
+	}
+
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
 
 // updateWithEnvs updates the config with the environment variables
@@ -50,6 +168,20 @@ func updateWithEnvs(config *Config) {
 	if ok {
 		config.Database.Postgres.Url = postgresURL
 	}
+
+	encryptionKeys, ok := os.LookupEnv(EncryptionKeysEnv)
+	if ok {
+		config.Encryption.Keys = map[string]string{}
+		for _, pair := range strings.Split(encryptionKeys, ",") {
+			id, key, _ := strings.Cut(strings.TrimSpace(pair), ":")
+			config.Encryption.Keys[strings.ToLower(id)] = key
+		}
+	}
+
+	primaryKey, ok := os.LookupEnv(EncryptionPrimaryKeyEnv)
+	if ok {
+		config.Encryption.PrimaryKey = primaryKey
+	}
 }
 
 // GetConf reads the config file and returns the Config struct
@@ -7,10 +7,12 @@ import (
 
 	config "github.com/maacarma/scheduler/config"
 	db "github.com/maacarma/scheduler/pkg/db"
-	svc "github.com/maacarma/scheduler/pkg/services/tasks"
+	leader "github.com/maacarma/scheduler/pkg/leader"
+	metrics "github.com/maacarma/scheduler/pkg/metrics"
 	tasks "github.com/maacarma/scheduler/pkg/services/tasks/transport"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 )
 
@@ -19,33 +21,26 @@ const (
 )
 
 // Start starts the API server
-func Start(ctx context.Context, scheduler svc.Scheduler, logger *zap.Logger, conf *config.Config) error {
-
-	dbClients, err := db.Connect(ctx, conf)
+// elector is nil when the leader election is disabled.
+func Start(ctx context.Context, dbClients *db.Clients, scheduler Scheduler, elector *leader.Elector, logger *zap.Logger, conf *config.Config) error {
+	r, err := newRouter(ctx, dbClients, scheduler, elector, conf)
 	if err != nil {
 		return err
 	}
 
-	r := gin.Default()
-	tasks.Activate(r, dbClients, scheduler)
-
 	errch := make(chan error)
 	server := &http.Server{
 		Addr:    conf.Application.Port,
 		Handler: r,
 	}
 
+	// the server is shut down so that the requests in-flight complete,
+	// the db connections are closed by the caller once the executions are drained.
 	defer func() {
-		logger.Warn("graceful shutting server and db connections")
+		logger.Warn("graceful shutting server")
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		if dbClients.Pg != nil {
-			dbClients.Pg.Close(shutdownCtx)
-		}
-		if dbClients.Mongo != nil {
-			dbClients.Mongo.Disconnect(shutdownCtx)
-		}
 		err := server.Shutdown(shutdownCtx)
 		if err != nil {
 			logger.Error(closingErr, zap.Error(err))
@@ -64,3 +59,18 @@ func Start(ctx context.Context, scheduler svc.Scheduler, logger *zap.Logger, con
 		return nil
 	}
 }
+
+// newRouter creates the router of the api, each request is traced by a server span.
+func newRouter(ctx context.Context, dbClients *db.Clients, scheduler Scheduler, elector *leader.Elector, conf *config.Config) (*gin.Engine, error) {
+	r := gin.Default()
+	r.Use(otelgin.Middleware(conf.Application.Name), metrics.Middleware())
+	if err := tasks.Activate(ctx, r, dbClients, scheduler, conf); err != nil {
+		return nil, err
+	}
+	r.GET("/leader", leaderStatus(elector))
+	r.GET("/metrics", metrics.Handler())
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz(dbClients, scheduler, elector))
+
+	return r, nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/maacarma/scheduler/config"
+	db "github.com/maacarma/scheduler/pkg/db"
+	tracing "github.com/maacarma/scheduler/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestRequestSpans(t *testing.T) {
+	conf := &config.Config{}
+	conf.Application.Name = "scheduler"
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(conf, exporter)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		provider.Shutdown(context.Background())
+	})
+
+	// the pool connects lazily, the traced route doesn't query the database
+	pool, err := pgxpool.New(context.Background(), "postgres://scheduler@127.0.0.1:1/scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	gin.SetMode(gin.TestMode)
+	router, err := newRouter(context.Background(), &db.Clients{Pg: pool}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/traced/:id", func(c *gin.Context) {
+		_, span := tracing.Tracer().Start(c.Request.Context(), "handler")
+		span.End()
+		c.Status(http.StatusNoContent)
+	})
+
+	// the request is part of the caller's trace
+	const traceID, callerSpanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodGet, "/traced/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+callerSpanID+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	// the handler ends before the request, so the request is exported last
+	handler, request := spans[0], spans[1]
+	if request.Name != "/traced/:id" || request.SpanKind != trace.SpanKindServer {
+		t.Fatalf("request span: got %q of kind %s", request.Name, request.SpanKind)
+	}
+	if !request.Parent.IsRemote() || request.Parent.TraceID().String() != traceID || request.Parent.SpanID().String() != callerSpanID {
+		t.Fatalf("request span: got parent %s/%s, want the caller's span", request.Parent.TraceID(), request.Parent.SpanID())
+	}
+	if handler.Name != "handler" || handler.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Fatalf("handler span: got %q, not a child of the request span", handler.Name)
+	}
+	for _, attr := range request.Attributes {
+		if attr.Key == "http.route" && attr.Value.AsString() != "/traced/:id" {
+			t.Fatalf("request span: got route %q", attr.Value.AsString())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	db "github.com/maacarma/scheduler/pkg/db"
+	leader "github.com/maacarma/scheduler/pkg/leader"
+	svc "github.com/maacarma/scheduler/pkg/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statuses of the health checks
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	// statusStandby is the status of the scheduler of a replica which isn't the leader.
+	statusStandby = "standby"
+	notRunning    = "cron isn't running"
+	notLoaded     = "active tasks aren't loaded yet"
+	pingTimeout   = 2 * time.Second
+)
+
+// Scheduler is the scheduler served by the api.
+type Scheduler interface {
+	svc.Scheduler
+	// Running checks if the scheduler is started, neither suspended nor stopped.
+	Running() bool
+	// Loaded checks if the active tasks were loaded since the scheduler started.
+	Loaded() bool
+}
+
+// component is the health of a component of the replica.
+type component struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readiness is the readiness of the replica along with each of its components.
+type readiness struct {
+	Status     string               `json:"status"`
+	Components map[string]component `json:"components"`
+}
+
+// healthz reports the process is alive.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": statusOK})
+}
+
+// readyz reports the replica is ready when the database is reachable and the scheduler
+// runs with the active tasks loaded. the scheduler of a replica which isn't the leader
+// is on standby, which doesn't make the replica unready.
+func readyz(dbClients *db.Clients, scheduler Scheduler, elector *leader.Elector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ready := readiness{Status: statusOK, Components: map[string]component{}}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), pingTimeout)
+		defer cancel()
+		ready.Components["database"] = check(dbClients.Ping(ctx))
+
+		switch {
+		case elector != nil && !elector.IsLeader():
+			ready.Components["scheduler"] = component{Status: statusStandby}
+		case !scheduler.Running():
+			ready.Components["scheduler"] = component{Status: statusUnavailable, Error: notRunning}
+		case !scheduler.Loaded():
+			ready.Components["scheduler"] = component{Status: statusUnavailable, Error: notLoaded}
+		default:
+			ready.Components["scheduler"] = component{Status: statusOK}
+		}
+
+		code := http.StatusOK
+		for _, comp := range ready.Components {
+			if comp.Status == statusUnavailable {
+				ready.Status = statusUnavailable
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		c.JSON(code, ready)
+	}
+}
+
+// check returns the health of a component by the error of its check.
+func check(err error) component {
+	if err != nil {
+		return component{Status: statusUnavailable, Error: err.Error()}
+	}
+	return component{Status: statusOK}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	leader "github.com/maacarma/scheduler/pkg/leader"
+
+	"github.com/gin-gonic/gin"
+)
+
+// leaderStatus returns the leadership status of the replica and the current leader.
+// without the leader election every replica runs the scheduler.
+func leaderStatus(elector *leader.Elector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if elector == nil {
+			c.JSON(http.StatusOK, leader.Status{Leader: true})
+			return
+		}
+
+		status, err := elector.Status(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
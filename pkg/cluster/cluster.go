@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maacarma/scheduler/config"
+	db "github.com/maacarma/scheduler/pkg/db"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	heartbeatErr      = "unable to send the membership heartbeat due to %v"
+	membersErr        = "unable to read the members due to %v"
+	leaveErr          = "unable to leave the cluster due to %v"
+	membershipLost    = "membership expired without heartbeats, releasing the shard"
+	membersChanged    = "cluster members changed"
+	invalidTTL        = "member ttl %s should be longer than the heartbeat interval %s"
+	defaultHeartbeat  = 5 * time.Second
+	defaultTTLPerBeat = 3
+)
+
+// members is the membership of the replicas, backed by the configured database.
+type members interface {
+	// heartbeat marks the replica alive for the ttl.
+	heartbeat(ctx context.Context, id string, ttl time.Duration) error
+	// alive returns the ids of the replicas alive.
+	alive(ctx context.Context) ([]string, error)
+	// leave removes the replica from the members.
+	leave(ctx context.Context, id string) error
+}
+
+// Cluster partitions the tasks across the live scheduler replicas.
+// Each replica heartbeats its membership in the database and the tasks are
+// assigned to the members with a consistent hash ring on the task id,
+// the shards are rebalanced whenever a member joins or leaves.
+type Cluster struct {
+	id        string
+	members   members
+	heartbeat time.Duration
+	ttl       time.Duration
+	logger    *zap.Logger
+
+	mu    sync.Mutex
+	alive []string
+	ring  *Ring
+	// lastBeat is the time of the last successful heartbeat
+	lastBeat time.Time
+}
+
+// New creates the cluster membership of the replica backed by the database of the clients.
+func New(ctx context.Context, dbClients *db.Clients, conf *config.Config, logger *zap.Logger) (*Cluster, error) {
+	heartbeat := conf.Cluster.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ttl := conf.Cluster.MemberTTL
+	if ttl <= 0 {
+		ttl = heartbeat * defaultTTLPerBeat
+	}
+	if ttl <= heartbeat {
+		return nil, fmt.Errorf(invalidTTL, ttl, heartbeat)
+	}
+
+	var m members
+	var err error
+	switch {
+	case dbClients.Pg != nil:
+		m = newPgMembers(dbClients.Pg)
+	case dbClients.Mongo != nil:
+		m, err = newMongoMembers(ctx, dbClients.Mongo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id := conf.ReplicaID()
+	return &Cluster{
+		id:        id,
+		members:   m,
+		heartbeat: heartbeat,
+		ttl:       ttl,
+		ring:      NewRing(nil),
+		logger:    logger.With(zap.String("replica", id)),
+	}, nil
+}
+
+// Run heartbeats the membership every heartbeat interval until the ctx is done,
+// rebalance is called with the shard of the replica whenever the members change.
+// the replica leaves the cluster when the ctx is done.
+func (c *Cluster) Run(ctx context.Context, rebalance func(shard models.Shard)) {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		c.sync(ctx, rebalance)
+
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), c.heartbeat)
+			defer cancel()
+			if err := c.members.leave(leaveCtx, c.id); err != nil {
+				c.logger.Warn(fmt.Sprintf(leaveErr, err))
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync heartbeats the membership and rebuilds the ring if the members changed.
+// a replica failing to heartbeat for the ttl is dropped by the other members,
+// so it releases its shard as well.
+func (c *Cluster) sync(ctx context.Context, rebalance func(shard models.Shard)) {
+	now := time.Now()
+	if err := c.members.heartbeat(ctx, c.id, c.ttl); err != nil {
+		c.logger.Warn(fmt.Sprintf(heartbeatErr, err))
+		if c.lastBeatBefore(now.Add(-c.ttl)) && c.setMembers(nil) {
+			c.logger.Warn(membershipLost)
+			rebalance(c.Shard())
+		}
+		return
+	}
+	c.mu.Lock()
+	c.lastBeat = now
+	c.mu.Unlock()
+
+	alive, err := c.members.alive(ctx)
+	if err != nil {
+		c.logger.Warn(fmt.Sprintf(membersErr, err))
+		return
+	}
+
+	if c.setMembers(alive) {
+		c.logger.Info(membersChanged, zap.Strings("members", alive))
+		rebalance(c.Shard())
+	}
+}
+
+// setMembers rebuilds the ring with the members, it returns false if they didn't change.
+func (c *Cluster) setMembers(alive []string) bool {
+	slices.Sort(alive)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.alive != nil && slices.Equal(c.alive, alive) {
+		return false
+	}
+
+	c.alive = alive
+	if c.alive == nil {
+		c.alive = []string{}
+	}
+	c.ring = NewRing(alive)
+	return true
+}
+
+func (c *Cluster) lastBeatBefore(t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastBeat.Before(t)
+}
+
+// Shard returns the shard of the replica as per the current ring.
+// the shard is fixed to the ring at the time of the call.
+func (c *Cluster) Shard() models.Shard {
+	c.mu.Lock()
+	ring := c.ring
+	c.mu.Unlock()
+
+	return func(taskID string) bool {
+		return ring.Owner(taskID) == c.id
+	}
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// member is the membership document of a replica.
+type member struct {
+	ID        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// mongoMembers is the membership backed by the members collection,
+// expired members are removed by a ttl index.
+//
+// the expiry is compared against the clocks of the replicas,
+// which should be in sync within a fraction of the ttl.
+type mongoMembers struct {
+	col *mongo.Collection
+}
+
+func newMongoMembers(ctx context.Context, client *mongo.Client) (*mongoMembers, error) {
+	col := client.Database("scheduler").Collection("members")
+	ttl := mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := col.Indexes().CreateOne(ctx, ttl); err != nil {
+		return nil, err
+	}
+
+	return &mongoMembers{col: col}, nil
+}
+
+func (m *mongoMembers) heartbeat(ctx context.Context, id string, ttl time.Duration) error {
+	update := bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(ttl)}}
+	_, err := m.col.UpdateOne(ctx, bson.M{"_id": id}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// alive returns the unexpired members, the ttl index removes the expired ones lazily.
+func (m *mongoMembers) alive(ctx context.Context) ([]string, error) {
+	cursor, err := m.col.Find(ctx, bson.M{"expires_at": bson.M{"$gte": time.Now().UTC()}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	members := []member{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.ID)
+	}
+
+	return ids, nil
+}
+
+func (m *mongoMembers) leave(ctx context.Context, id string) error {
+	_, err := m.col.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
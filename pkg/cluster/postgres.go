@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// the expiry is computed with the database clock, so the replicas' clocks don't matter
+const (
+	heartbeatMember = `
+INSERT INTO members (id, expires_at) VALUES ($1, now() + make_interval(secs => $2))
+ON CONFLICT (id) DO UPDATE SET expires_at = EXCLUDED.expires_at`
+	deleteExpiredMembers = `DELETE FROM members WHERE expires_at < now()`
+	aliveMembers         = `SELECT id FROM members WHERE expires_at >= now()`
+	deleteMember         = `DELETE FROM members WHERE id = $1`
+)
+
+// pgMembers is the membership backed by the members table.
+type pgMembers struct {
+	pool *pgxpool.Pool
+}
+
+func newPgMembers(pool *pgxpool.Pool) *pgMembers {
+	return &pgMembers{pool: pool}
+}
+
+func (m *pgMembers) heartbeat(ctx context.Context, id string, ttl time.Duration) error {
+	_, err := m.pool.Exec(ctx, heartbeatMember, id, ttl.Seconds())
+	return err
+}
+
+// alive returns the unexpired members, the expired ones are removed along.
+func (m *pgMembers) alive(ctx context.Context) ([]string, error) {
+	if _, err := m.pool.Exec(ctx, deleteExpiredMembers); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.pool.Query(ctx, aliveMembers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (m *pgMembers) leave(ctx context.Context, id string) error {
+	_, err := m.pool.Exec(ctx, deleteMember, id)
+	return err
+}
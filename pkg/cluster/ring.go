@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+)
+
+// replicas is the count of virtual nodes of a member on the ring,
+// which spreads the tasks evenly across a handful of members.
+const replicas = 128
+
+// Ring is a consistent hash ring of the members,
+// a member joining or leaving moves only the tasks of its share.
+type Ring struct {
+	hashes []uint64
+	owners map[uint64]string
+}
+
+// NewRing creates a ring of the given members.
+func NewRing(members []string) *Ring {
+	r := &Ring{owners: make(map[uint64]string, len(members)*replicas)}
+	for _, member := range members {
+		for i := 0; i < replicas; i++ {
+			h := hash(fmt.Sprintf("%s#%d", member, i))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = member
+		}
+	}
+	slices.Sort(r.hashes)
+
+	return r
+}
+
+// Owner returns the member owning the key, the first member clockwise from the key's hash.
+// It returns empty for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+// hash hashes the key onto the ring, fnv is mixed with the splitmix64 finalizer
+// as it spreads the similar keys (Ex: sequential ids) poorly on its own.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/maacarma/scheduler/config"
 	"github.com/maacarma/scheduler/pkg/db/mongodb"
 	"github.com/maacarma/scheduler/pkg/db/postgres"
@@ -22,10 +22,11 @@ const (
 
 type Clients struct {
 	Mongo *mongo.Client
-	Pg    *pgx.Conn
+	Pg    *pgxpool.Pool
 }
 
 // Connect connects to the database and returns the connection.
+// the clients are shared by the components of the process.
 func Connect(ctx context.Context, conf *config.Config) (*Clients, error) {
 
 	db := conf.Database.Db
@@ -54,3 +55,28 @@ func Connect(ctx context.Context, conf *config.Config) (*Clients, error) {
 		return nil, fmt.Errorf(unkDbErr, db)
 	}
 }
+
+// Close closes the connections of the active client.
+func (c *Clients) Close(ctx context.Context) error {
+	switch {
+	case c.Pg != nil:
+		c.Pg.Close()
+		return nil
+	case c.Mongo != nil:
+		return c.Mongo.Disconnect(ctx)
+	default:
+		return nil
+	}
+}
+
+// Ping pings the database of the active client.
+func (c *Clients) Ping(ctx context.Context) error {
+	switch {
+	case c.Pg != nil:
+		return c.Pg.Ping(ctx)
+	case c.Mongo != nil:
+		return c.Mongo.Ping(ctx, nil)
+	default:
+		return fmt.Errorf(unkDbErr, "none")
+	}
+}
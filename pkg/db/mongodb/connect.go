@@ -11,9 +11,10 @@ import (
 // Connect connects to the mongodb server and returns the client.
 // It checks the connection by pinging the server.
 // It returns an error if the connection/ping fails.
+// the commands are traced as part of the traces of their context.
 func Connect(ctx context.Context, connString string) (*mongo.Client, error) {
 	timeout := time.Second * 5
-	opts := options.Client().SetServerSelectionTimeout(timeout)
+	opts := options.Client().SetServerSelectionTimeout(timeout).SetMonitor((&tracer{}).monitor())
 
 	client, err := mongo.Connect(ctx, opts.ApplyURI(connString))
 	if err != nil {
@@ -0,0 +1,64 @@
+package mongodb
+
+import (
+	"context"
+	"sync"
+
+	tracing "github.com/maacarma/scheduler/pkg/tracing"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commandKey identifies a command in-flight.
+type commandKey struct {
+	conn    string
+	request int64
+}
+
+// tracer traces the commands run as part of a trace (Ex: of an api request or an execution),
+// the commands outside of a trace (Ex: heartbeats) aren't traced.
+type tracer struct {
+	spans sync.Map
+}
+
+// monitor returns the command monitor of the tracer.
+func (t *tracer) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: t.started,
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			t.finished(&e.CommandFinishedEvent, "")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			t.finished(&e.CommandFinishedEvent, e.Failure)
+		},
+	}
+}
+
+func (t *tracer) started(ctx context.Context, e *event.CommandStartedEvent) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	_, span := tracing.Tracer().Start(ctx, e.CommandName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemMongoDB,
+		semconv.DBOperationName(e.CommandName),
+		semconv.DBNamespace(e.DatabaseName),
+	))
+	t.spans.Store(commandKey{conn: e.ConnectionID, request: e.RequestID}, span)
+}
+
+func (t *tracer) finished(e *event.CommandFinishedEvent, failure string) {
+	value, ok := t.spans.LoadAndDelete(commandKey{conn: e.ConnectionID, request: e.RequestID})
+	if !ok {
+		return
+	}
+
+	span := value.(trace.Span)
+	if failure != "" {
+		span.SetStatus(codes.Error, failure)
+	}
+	span.End()
+}
@@ -5,14 +5,28 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Connect creates a connection to the postgres server.
+// heldConns is the number of connections held by the leader lock and the watcher.
+const heldConns = 2
+
+// Connect creates a connection pool to the postgres server.
+// the pool is safe for the concurrent use of the api and the running tasks.
 // It checks the connection by pinging the server.
 // It returns an error if the connection/ping fails.
-func Connect(ctx context.Context, connString string) (*pgx.Conn, error) {
-	conn, err := pgx.Connect(ctx, connString)
+// the queries are traced as part of the traces of their context.
+func Connect(ctx context.Context, connString string) (*pgxpool.Pool, error) {
+	conf, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to postgres: %w", err)
+	}
+	conf.ConnConfig.Tracer = tracer{}
+	// the pool is shared by the whole process, the leader lock and the watcher
+	// hold a connection each for as long as they run.
+	conf.MaxConns += heldConns
+
+	conn, err := pgxpool.NewWithConfig(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to postgres: %w", err)
 	}
@@ -28,17 +42,29 @@ func Connect(ctx context.Context, connString string) (*pgx.Conn, error) {
 	return conn, nil
 }
 
+// schemaLock serializes the schema initialization of the replicas starting together.
+const schemaLock = `SELECT pg_advisory_xact_lock(hashtext('scheduler.schema'))`
+
 // initialize creates the schema in the postgres database.
-func initialize(ctx context.Context, pgxConn *pgx.Conn) error {
+// the schema is created in a single transaction, guarded by an advisory lock.
+func initialize(ctx context.Context, pgxConn *pgxpool.Pool) error {
 	path := "pkg/services/tasks/store/postgres/sql/schema.sql"
 	c, ioErr := os.ReadFile(path)
 	if ioErr != nil {
 		return fmt.Errorf("error reading sql file %w", ioErr)
 	}
 
-	_, err := pgxConn.Exec(ctx, string(c))
+	tx, err := pgxConn.Begin(ctx)
 	if err != nil {
+		return fmt.Errorf("error beginning schema transaction %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, schemaLock); err != nil {
+		return fmt.Errorf("error locking schema %w", err)
+	}
+	if _, err := tx.Exec(ctx, string(c)); err != nil {
 		return fmt.Errorf("error executing sql file %w", err)
 	}
-	return nil
+	return tx.Commit(ctx)
 }
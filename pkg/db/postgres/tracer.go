@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+	tracing "github.com/maacarma/scheduler/pkg/tracing"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryName matches the name of a sqlc query from its leading comment.
+var queryName = regexp.MustCompile(`^-- name: (\w+)`)
+
+// spanKey is the context key of the span of a query.
+type spanKey struct{}
+
+// tracer traces the queries run as part of a trace (Ex: of an api request or an execution),
+// the queries outside of a trace (Ex: heartbeats) aren't traced.
+type tracer struct{}
+
+func (tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	name := "query"
+	if match := queryName.FindStringSubmatch(data.SQL); match != nil {
+		name = match[1]
+	}
+	_, span := tracing.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(name),
+	))
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
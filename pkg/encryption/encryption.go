@@ -0,0 +1,217 @@
+// Package encryption encrypts the secrets of the tasks at rest with envelope encryption.
+//
+// Each value is encrypted with AES-256-GCM under a random data key, the data key is
+// encrypted (wrapped) with a key encryption key of the keyring and stored along with the value:
+//
+//	enc:v1:<key id>:<wrapped data key>:<encrypted value>
+//
+// The keyring encrypts with its primary key and decrypts with any of its keys,
+// so that the keys can be rotated by adding a new primary key and re-encrypting the
+// stored values with it (see cmd/rotate-keys) before the old key is removed.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	config "github.com/maacarma/scheduler/config"
+)
+
+// Prefix marks the values encrypted by a keyring.
+const Prefix = "enc:v1:"
+
+// keySize is the size of the keys, AES-256.
+const keySize = 32
+
+const (
+	invalidKey     = "invalid encryption key %s: %s"
+	unknownKey     = "unknown encryption key %s"
+	unknownPrimary = "unknown primary encryption key %s"
+)
+
+// ErrDisabled is returned when an encrypted value is read while no keys are configured.
+var ErrDisabled = errors.New("encrypted value found, but no encryption keys are configured")
+
+// ErrMalformed is returned when an encrypted value can't be parsed.
+var ErrMalformed = errors.New("malformed encrypted value")
+
+var encoding = base64.RawStdEncoding
+
+// Keyring encrypts and decrypts the values with its keys.
+// A keyring without keys is disabled, the values are left in plain.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// New creates a keyring of the keys by id, encrypting with the primary key.
+// The keys are 32 bytes each, the key ids can't contain a colon.
+func New(keys map[string][]byte, primary string) (*Keyring, error) {
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	if len(keys) == 0 {
+		return k, nil
+	}
+
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf(invalidKey, id, "the id should be non-empty without colons")
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf(invalidKey, id, fmt.Sprintf("the key should be %d bytes", keySize))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf(invalidKey, id, err.Error())
+		}
+		k.keys[id] = aead
+	}
+
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf(unknownPrimary, primary)
+	}
+
+	return k, nil
+}
+
+// FromConfig creates the keyring of the base64 encoded keys in the config,
+// a single key is the primary key unless configured.
+func FromConfig(conf *config.Config) (*Keyring, error) {
+	keys := make(map[string][]byte, len(conf.Encryption.Keys))
+	primary := strings.ToLower(conf.Encryption.PrimaryKey)
+	for id, encoded := range conf.Encryption.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf(invalidKey, id, err.Error())
+		}
+		keys[id] = key
+
+		if primary == "" && len(conf.Encryption.Keys) == 1 {
+			primary = id
+		}
+	}
+
+	return New(keys, primary)
+}
+
+// Enabled checks if the keyring has keys to encrypt with.
+func (k *Keyring) Enabled() bool {
+	return len(k.keys) > 0
+}
+
+// Encrypt encrypts the plaintext with a new data key wrapped by the primary key.
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	kek, ok := k.keys[k.primary]
+	if !ok {
+		return "", ErrDisabled
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(aead, plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+	// the key id is authenticated along with the data key, so that it can't be swapped
+	wrapped, err := seal(kek, dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + k.primary + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts the value encrypted by Encrypt with any of the keys.
+func (k *Keyring) Decrypt(value string) ([]byte, error) {
+	id, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if !k.Enabled() {
+		return nil, ErrDisabled
+	}
+
+	kek, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf(unknownKey, id)
+	}
+
+	dataKey, err := open(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext, nil)
+}
+
+// Encrypted checks if the value is encrypted.
+func (k *Keyring) Encrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Current checks if the value is encrypted with the primary key.
+func (k *Keyring) Current(value string) bool {
+	id, _, _, err := parse(value)
+	return err == nil && id == k.primary
+}
+
+// parse parses the key id, the wrapped data key and the ciphertext of the encrypted value.
+func parse(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if !strings.HasPrefix(value, Prefix) || len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+
+	return parts[0], wrapped, ciphertext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+// open decrypts the ciphertext sealed by seal.
+func open(aead cipher.AEAD, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, data)
+}
@@ -0,0 +1,153 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey returns a 32 bytes key filled with the byte.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func newKeyring(t *testing.T, keys map[string][]byte, primary string) *Keyring {
+	t.Helper()
+	k, err := New(keys, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := newKeyring(t, map[string][]byte{"k1": testKey(1)}, "k1")
+
+	for _, plaintext := range []string{"s3cr3t", "", `{"token":"abc"}`} {
+		value, err := k.Encrypt([]byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(value, Prefix+"k1:") || !k.Encrypted(value) || !k.Current(value) {
+			t.Fatalf("got value %q, want it encrypted with k1", value)
+		}
+		if plaintext != "" && strings.Contains(value, plaintext) {
+			t.Fatalf("value %q contains the plaintext", value)
+		}
+
+		decrypted, err := k.Decrypt(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != plaintext {
+			t.Fatalf("got %q, want %q", decrypted, plaintext)
+		}
+	}
+
+	// each value has its own data key and nonces
+	a, _ := k.Encrypt([]byte("same"))
+	b, _ := k.Encrypt([]byte("same"))
+	if a == b {
+		t.Fatal("the same plaintext is encrypted alike")
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	old := newKeyring(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	value, err := old.Encrypt([]byte("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newKeyring(t, map[string][]byte{"k2": testKey(2)}, "k2")
+	if _, err := k.Decrypt(value); err == nil || !strings.Contains(err.Error(), "unknown encryption key k1") {
+		t.Fatalf("got %v, want the unknown key error", err)
+	}
+
+	disabled := newKeyring(t, nil, "")
+	if _, err := disabled.Decrypt(value); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("disabled keyring: got %v, want %v", err, ErrDisabled)
+	}
+}
+
+func TestTamperDetection(t *testing.T) {
+	k := newKeyring(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k1")
+	value, err := k.Encrypt([]byte("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+
+	// flip flips a bit of the decoded part, at the end past the nonce
+	flip := func(part string) string {
+		raw, err := encoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		return encoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name      string
+		value     string
+		malformed bool
+	}{
+		{name: "tampered ciphertext", value: Prefix + "k1:" + parts[1] + ":" + flip(parts[2])},
+		{name: "tampered data key", value: Prefix + "k1:" + flip(parts[1]) + ":" + parts[2]},
+		// the key id is authenticated along with the data key
+		{name: "swapped key id", value: Prefix + "k2:" + parts[1] + ":" + parts[2]},
+		{name: "missing part", value: Prefix + "k1:" + parts[1], malformed: true},
+		{name: "invalid encoding", value: Prefix + "k1:" + parts[1] + ":!!", malformed: true},
+		{name: "truncated ciphertext", value: Prefix + "k1:" + parts[1] + ":AAAA", malformed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := k.Decrypt(tt.value)
+			if err == nil {
+				t.Fatalf("got %q, want an error", decrypted)
+			}
+			if tt.malformed && !errors.Is(err, ErrMalformed) {
+				t.Fatalf("got %v, want %v", err, ErrMalformed)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := newKeyring(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	value, err := old.Encrypt([]byte("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new primary key is added, the old key is kept to decrypt the stored values
+	rotated := newKeyring(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	if rotated.Current(value) {
+		t.Fatal("the value of the old key is current")
+	}
+	decrypted, err := rotated.Decrypt(value)
+	if err != nil || string(decrypted) != "s3cr3t" {
+		t.Fatalf("old value: got %q, %v", decrypted, err)
+	}
+
+	// the value is re-wrapped under the new key
+	rewrapped, err := rotated.Encrypt(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rewrapped, Prefix+"k2:") || !rotated.Current(rewrapped) {
+		t.Fatalf("got %q, want it encrypted with k2", rewrapped)
+	}
+
+	// once the old key is removed, only the re-wrapped value decrypts
+	retired := newKeyring(t, map[string][]byte{"k2": testKey(2)}, "k2")
+	if decrypted, err := retired.Decrypt(rewrapped); err != nil || string(decrypted) != "s3cr3t" {
+		t.Fatalf("re-wrapped value: got %q, %v", decrypted, err)
+	}
+	if _, err := retired.Decrypt(value); err == nil {
+		t.Fatal("the value of the removed key decrypts")
+	}
+}
@@ -1,9 +1,14 @@
 package errors
 
+import (
+	"errors"
+	"fmt"
+)
+
 type Validation struct {
 	Key         string `json:"key"`
 	Description string `json:"description"`
-	Error       string `json:"error,omitempty"`
+	Err         string `json:"error,omitempty"`
 }
 
 const (
@@ -11,6 +16,9 @@ const (
 	InvalidFieldMsg  = "invalid field"
 )
 
+// ErrTaskNotFound is the error returned by the repositories when no task matches the id
+var ErrTaskNotFound = errors.New("task not found")
+
 // ErrInvalidTaskPayload is the error returned when the payload is invalid
 // Expects args array of strings in following format
 // args[0]: key of the field
@@ -21,8 +29,17 @@ func InvalidPayload(args ...string) *Validation {
 	case 2:
 		return &Validation{Key: args[0], Description: args[1]}
 	case 3:
-		return &Validation{Key: args[0], Description: args[1], Error: args[2]}
+		return &Validation{Key: args[0], Description: args[1], Err: args[2]}
 	default:
 		return &Validation{}
 	}
 }
+
+// Error implements the error interface, so that a validation can be returned as an error.
+func (v *Validation) Error() string {
+	if v.Err == "" {
+		return fmt.Sprintf("%s: %s", v.Key, v.Description)
+	}
+
+	return fmt.Sprintf("%s: %s, %s", v.Key, v.Description, v.Err)
+}
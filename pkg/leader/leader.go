@@ -0,0 +1,206 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/maacarma/scheduler/config"
+	db "github.com/maacarma/scheduler/pkg/db"
+
+	"go.uber.org/zap"
+)
+
+const (
+	elected          = "elected as the leader"
+	demoted          = "lost the leadership"
+	campaignErr      = "unable to campaign for the leadership due to %v"
+	unableToLead     = "unable to lead due to %v"
+	unableToResign   = "unable to resign the leadership due to %v"
+	invalidRenewal   = "leader renew interval %s should be shorter than the lease %s"
+	defaultLease     = 15 * time.Second
+	defaultRenewRate = 3
+)
+
+// lock is a lock held by at most one replica at a time,
+// backed by the configured database.
+type lock interface {
+	// acquire acquires or renews the lock, it returns false if another replica holds it.
+	acquire(ctx context.Context) (bool, error)
+	// release releases the lock if held.
+	release(ctx context.Context) error
+	// holder returns the id of the replica holding the lock, empty if none.
+	holder(ctx context.Context) (string, error)
+}
+
+// Status is the leadership status of the replica.
+// Election is unset when the leader election is disabled, every replica leads then.
+type Status struct {
+	Election bool       `json:"election"`
+	ID       string     `json:"id"`
+	Leader   bool       `json:"leader"`
+	LeaderID string     `json:"leader_id"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// Elector campaigns for the leadership among the scheduler replicas,
+// so that only the leader runs the scheduler.
+// A replica losing the connection to the database steps down,
+// and another replica takes over once the leadership is released or expired.
+type Elector struct {
+	id     string
+	lock   lock
+	lease  time.Duration
+	renew  time.Duration
+	logger *zap.Logger
+
+	// renewed is when the last successful renewal was requested,
+	// the lease is counted from then.
+	renewed time.Time
+
+	mu     sync.Mutex
+	leader bool
+	since  time.Time
+}
+
+// New creates an elector backed by the database of the clients.
+// the lock is named after the application, so that the replicas of an application compete for it.
+func New(ctx context.Context, dbClients *db.Clients, conf *config.Config, logger *zap.Logger) (*Elector, error) {
+	lease := conf.Leader.Lease
+	if lease <= 0 {
+		lease = defaultLease
+	}
+	renew := conf.Leader.RenewInterval
+	if renew <= 0 {
+		renew = lease / defaultRenewRate
+	}
+	if renew >= lease {
+		return nil, fmt.Errorf(invalidRenewal, renew, lease)
+	}
+
+	id := conf.ReplicaID()
+
+	name := conf.Application.Name
+	var l lock
+	var err error
+	switch {
+	case dbClients.Pg != nil:
+		l = newPgLock(dbClients.Pg, id, lockKey(name))
+	case dbClients.Mongo != nil:
+		l, err = newMongoLock(ctx, dbClients.Mongo, id, name, lease)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Elector{id: id, lock: l, lease: lease, renew: renew, logger: logger.With(zap.String("replica", id))}, nil
+}
+
+// Run campaigns for the leadership every renew interval until the ctx is done.
+// lead is called when the replica is elected, the leadership is released if it fails.
+// step is called when the replica loses the leadership or the ctx is done.
+func (e *Elector) Run(ctx context.Context, lead func() error, step func()) {
+	ticker := time.NewTicker(e.renew)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx, lead, step)
+
+		select {
+		case <-ctx.Done():
+			if e.IsLeader() {
+				e.setLeader(false)
+				step()
+			}
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign acquires or renews the leadership and calls lead or step on a change.
+// a replica that can't reach the database steps down, as its leadership can't be confirmed.
+// each attempt is bounded by the renew interval, which is shorter than the lease,
+// so a hung database can't keep a replica leading past its lease.
+func (e *Elector) campaign(ctx context.Context, lead func() error, step func()) {
+	attempt := time.Now()
+	acquireCtx, cancel := context.WithTimeout(ctx, e.renew)
+	ok, err := e.lock.acquire(acquireCtx)
+	cancel()
+	if err != nil {
+		e.logger.Warn(fmt.Sprintf(campaignErr, err))
+	}
+	if ok {
+		e.renewed = attempt
+	}
+	// the lease may have expired while renewing, another replica can hold it by now
+	expired := time.Since(e.renewed) >= e.lease
+
+	switch {
+	case ok && !expired && !e.IsLeader():
+		e.setLeader(true)
+		e.logger.Info(elected)
+		if err := lead(); err != nil {
+			e.logger.Error(fmt.Sprintf(unableToLead, err))
+			e.setLeader(false)
+			step()
+			e.resign()
+		}
+	case (!ok || expired) && e.IsLeader():
+		e.setLeader(false)
+		e.logger.Warn(demoted)
+		step()
+	}
+}
+
+// resign releases the leadership, so that another replica can take over right away.
+func (e *Elector) resign() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.renew)
+	defer cancel()
+	if err := e.lock.release(ctx); err != nil {
+		e.logger.Warn(fmt.Sprintf(unableToResign, err))
+	}
+}
+
+// IsLeader checks if the replica is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+	e.since = time.Now().UTC()
+}
+
+// Status returns the leadership status of the replica along with the current leader.
+func (e *Elector) Status(ctx context.Context) (Status, error) {
+	e.mu.Lock()
+	status := Status{Election: true, ID: e.id, Leader: e.leader}
+	if e.leader {
+		since := e.since
+		status.Since = &since
+	}
+	e.mu.Unlock()
+
+	holder, err := e.lock.holder(ctx)
+	if err != nil {
+		return status, err
+	}
+	status.LeaderID = holder
+
+	return status, nil
+}
+
+// lockKey hashes the lock name into a postgres advisory lock key.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
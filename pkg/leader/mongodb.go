@@ -0,0 +1,88 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lease is the lease document of the mongo lock.
+type lease struct {
+	Name      string    `bson:"_id"`
+	Holder    string    `bson:"holder"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// mongoLock is a lock backed by a lease document, renewed by its holder
+// and taken over by another replica once expired.
+// expired leases are also removed by a ttl index.
+//
+// the expiry is compared against the clocks of the replicas,
+// which should be in sync within a fraction of the lease.
+type mongoLock struct {
+	col   *mongo.Collection
+	id    string
+	name  string
+	lease time.Duration
+}
+
+func newMongoLock(ctx context.Context, client *mongo.Client, id, name string, lease time.Duration) (*mongoLock, error) {
+	col := client.Database("scheduler").Collection("leases")
+	ttl := mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := col.Indexes().CreateOne(ctx, ttl); err != nil {
+		return nil, err
+	}
+
+	return &mongoLock{col: col, id: id, name: name, lease: lease}, nil
+}
+
+// acquire renews the lease if held or takes it over if expired.
+// the lease held by another replica fails the upsert with a duplicate key error.
+func (l *mongoLock) acquire(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": l.name,
+		"$or": bson.A{
+			bson.M{"holder": l.id},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"holder": l.id, "expires_at": now.Add(l.lease)}}
+
+	_, err := l.col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// release removes the lease if held.
+func (l *mongoLock) release(ctx context.Context) error {
+	_, err := l.col.DeleteOne(ctx, bson.M{"_id": l.name, "holder": l.id})
+	return err
+}
+
+// holder returns the holder of the unexpired lease.
+func (l *mongoLock) holder(ctx context.Context) (string, error) {
+	var current lease
+	filter := bson.M{"_id": l.name, "expires_at": bson.M{"$gte": time.Now().UTC()}}
+	err := l.col.FindOne(ctx, filter).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return current.Holder, nil
+}
@@ -0,0 +1,98 @@
+package leader
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	setApplicationName = `SELECT set_config('application_name', $1, false)`
+	tryAdvisoryLock    = `SELECT pg_try_advisory_lock($1)`
+	advisoryUnlock     = `SELECT pg_advisory_unlock($1)`
+	// a bigint advisory lock is listed with its high and low 32 bits in classid and objid
+	advisoryLockHolder = `
+SELECT a.application_name FROM pg_locks l
+JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+  AND l.classid::bigint = ($1::bigint >> 32) & 4294967295 AND l.objid::bigint = $1::bigint & 4294967295`
+)
+
+// pgLock is a lock backed by a postgres session level advisory lock.
+// the lock is held by a dedicated connection of the pool and released by the server
+// as soon as the session ends, so a crashed leader is replaced right away.
+type pgLock struct {
+	pool *pgxpool.Pool
+	conn *pgxpool.Conn
+	id   string
+	key  int64
+}
+
+func newPgLock(pool *pgxpool.Pool, id string, key int64) *pgLock {
+	return &pgLock{pool: pool, id: id, key: key}
+}
+
+// acquire tries to acquire the advisory lock,
+// once acquired it checks that the session holding the lock is alive.
+func (l *pgLock) acquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err != nil {
+			// the session is gone along with the lock
+			l.conn.Conn().Close(ctx)
+			l.conn.Release()
+			l.conn = nil
+			return false, err
+		}
+		return true, nil
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// the application name identifies the holder of the lock
+	if _, err := conn.Exec(ctx, setApplicationName, l.id); err != nil {
+		conn.Release()
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, tryAdvisoryLock, l.key).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, err
+	}
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+// release unlocks the advisory lock and returns the connection to the pool.
+func (l *pgLock) release(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+
+	conn := l.conn
+	l.conn = nil
+	defer conn.Release()
+	_, err := conn.Exec(ctx, advisoryUnlock, l.key)
+	return err
+}
+
+// holder returns the application name of the session holding the advisory lock.
+func (l *pgLock) holder(ctx context.Context) (string, error) {
+	var holder string
+	err := l.pool.QueryRow(ctx, advisoryLockHolder, l.key).Scan(&holder)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	return holder, err
+}
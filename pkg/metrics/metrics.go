@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scheduler"
+
+// noResponse is the status class of the executions which got no http response.
+const noResponse = "none"
+
+var (
+	executions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "executions_total",
+		Help:      "Executions of the tasks by task namespace, execution status and http status class.",
+	}, []string{"namespace", "status", "status_class"})
+
+	executionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "execution_duration_seconds",
+		Help:      "Duration of the executed runs of the tasks, including the retries.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"namespace"})
+
+	retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "execution_retries_total",
+		Help:      "Attempts retried by the retry policies of the tasks.",
+	}, []string{"namespace"})
+
+	missedFires = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "missed_fires_total",
+		Help:      "Fire times of the tasks missed while the scheduler was down.",
+	}, []string{"namespace"})
+
+	scheduleLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "schedule_lag_seconds",
+		Help:      "Delay between the intended and the actual fire time of the scheduled runs.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"namespace"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests served by the api by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the requests served by the api by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// ObserveExecution records the outcome of an execution of the task in the given namespace.
+// the runs which weren't executed (Ex: skipped) are counted without a duration.
+func ObserveExecution(ns string, execution *models.Execution) {
+	executions.WithLabelValues(ns, execution.Status, statusClass(execution.StatusCode)).Inc()
+	if len(execution.Attempts) == 0 {
+		return
+	}
+
+	executionDuration.WithLabelValues(ns).Observe(execution.EndedAt.Sub(execution.StartedAt).Seconds())
+	if len(execution.Attempts) > 1 {
+		retries.WithLabelValues(ns).Add(float64(len(execution.Attempts) - 1))
+	}
+}
+
+// ObserveMissedFires records the fire times of the task in the given namespace missed while down.
+func ObserveMissedFires(ns string, count int) {
+	missedFires.WithLabelValues(ns).Add(float64(count))
+}
+
+// ObserveScheduleLag records the delay of a scheduled run of the task in the given namespace.
+func ObserveScheduleLag(ns string, lag time.Duration) {
+	scheduleLag.WithLabelValues(ns).Observe(max(lag, 0).Seconds())
+}
+
+// RegisterScheduledTasks reports the count of the tasks scheduled as a gauge,
+// it is called once by the scheduler.
+func RegisterScheduledTasks(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduled_tasks",
+		Help:      "Tasks scheduled in the scheduler of the replica.",
+	}, func() float64 { return float64(count()) })
+}
+
+// Middleware records the requests served by the gin router.
+// the requests matching no route are recorded under an empty route.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the prometheus format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// statusClass returns the class of the http status code (Ex: 2xx).
+func statusClass(code int) string {
+	if code == 0 {
+		return noResponse
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
@@ -0,0 +1,176 @@
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// timerKind is the kind of a task's timer, a task has at most one timer of each kind.
+type timerKind int
+
+const (
+	// startTimer adds the pending task to the cron at its start time.
+	startTimer timerKind = iota
+	// stopTimer discards the task after its end time.
+	stopTimer
+)
+
+type timerKey struct {
+	taskID string
+	kind   timerKind
+}
+
+// timer is a function fired at a time, index is its position in the heap.
+// seq orders the timers of the same time by when they were scheduled.
+type timer struct {
+	key   timerKey
+	at    time.Time
+	seq   uint64
+	fire  func()
+	index int
+}
+
+// timerHeap is a min-heap of the timers ordered by their time, then by their schedule order.
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return t
+}
+
+// dispatcher fires the start and stop timers of the tasks from a single goroutine,
+// it waits for the earliest timer only, so it scales with the tasks in goroutines and memory.
+//
+// the timers are fired one after the other, so a fire func shouldn't block.
+// the timers of the same time are fired in the order they were scheduled.
+// a timer may still fire right after being cancelled, as it is popped before firing,
+// the fire funcs should check the task is still due.
+type dispatcher struct {
+	mu     sync.Mutex
+	timers timerHeap
+	keys   map[timerKey]*timer
+	seq    uint64
+	// wake wakes the loop up to wait for a new earliest timer
+	wake chan struct{}
+	// now is the clock of the dispatcher, replaced by the tests
+	now func() time.Time
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		keys: make(map[timerKey]*timer),
+		wake: make(chan struct{}, 1),
+		now:  time.Now,
+	}
+}
+
+// run fires the due timers until the ctx is done.
+func (d *dispatcher) run(ctx context.Context) {
+	wait := time.NewTimer(time.Hour)
+	defer wait.Stop()
+
+	for {
+		for _, fire := range d.due(d.now()) {
+			fire()
+		}
+
+		wait.Reset(d.untilNext())
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-wait.C:
+		}
+	}
+}
+
+// due pops the timers due by now, the earliest first.
+func (d *dispatcher) due(now time.Time) []func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var fires []func()
+	for len(d.timers) > 0 && !d.timers[0].at.After(now) {
+		t := heap.Pop(&d.timers).(*timer)
+		delete(d.keys, t.key)
+		fires = append(fires, t.fire)
+	}
+	return fires
+}
+
+// untilNext returns the duration until the earliest timer, an hour if there is none.
+func (d *dispatcher) untilNext() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.timers) == 0 {
+		return time.Hour
+	}
+	return max(d.timers[0].at.Sub(d.now()), 0)
+}
+
+// schedule fires the func at the time, replacing the timer of the same kind of the task.
+func (d *dispatcher) schedule(taskID string, kind timerKind, at time.Time, fire func()) {
+	d.mu.Lock()
+	key := timerKey{taskID: taskID, kind: kind}
+	d.seq++
+	if t, exists := d.keys[key]; exists {
+		t.at = at
+		t.seq = d.seq
+		t.fire = fire
+		heap.Fix(&d.timers, t.index)
+	} else {
+		t := &timer{key: key, at: at, seq: d.seq, fire: fire}
+		heap.Push(&d.timers, t)
+		d.keys[key] = t
+	}
+	d.mu.Unlock()
+
+	d.notify()
+}
+
+// cancel removes the timers of the task.
+func (d *dispatcher) cancel(taskID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, kind := range []timerKind{startTimer, stopTimer} {
+		key := timerKey{taskID: taskID, kind: kind}
+		if t, exists := d.keys[key]; exists {
+			heap.Remove(&d.timers, t.index)
+			delete(d.keys, key)
+		}
+	}
+}
+
+func (d *dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,146 @@
+package schedule
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// dueAt is the timers expected to fire once the clock reaches the offset from the start.
+type dueAt struct {
+	after time.Duration
+	want  []string
+}
+
+func TestDispatcher(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// schedule schedules the timers, fire returns a func recording the name once fired
+		schedule func(d *dispatcher, fire func(name string) func())
+		due      []dueAt
+	}{
+		{
+			name: "earliest first",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				d.schedule("2", startTimer, start.Add(2*time.Second), fire("2 start"))
+				d.schedule("1", stopTimer, start.Add(3*time.Second), fire("1 stop"))
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 start"))
+			},
+			due: []dueAt{
+				{after: 0},
+				{after: time.Second, want: []string{"1 start"}},
+				{after: 5 * time.Second, want: []string{"2 start", "1 stop"}},
+			},
+		},
+		{
+			name: "rescheduling replaces the timer",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 start"))
+				d.schedule("1", startTimer, start.Add(3*time.Second), fire("1 rescheduled"))
+			},
+			due: []dueAt{
+				{after: 2 * time.Second},
+				{after: 3 * time.Second, want: []string{"1 rescheduled"}},
+				{after: time.Hour},
+			},
+		},
+		{
+			name: "rescheduling earlier fires sooner",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				d.schedule("1", startTimer, start.Add(time.Hour), fire("1 start"))
+				d.schedule("2", startTimer, start.Add(2*time.Second), fire("2 start"))
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 rescheduled"))
+			},
+			due: []dueAt{
+				{after: 2 * time.Second, want: []string{"1 rescheduled", "2 start"}},
+				{after: time.Hour},
+			},
+		},
+		{
+			name: "discarded while waiting",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 start"))
+				d.schedule("1", stopTimer, start.Add(2*time.Second), fire("1 stop"))
+				d.schedule("2", startTimer, start.Add(time.Second), fire("2 start"))
+				d.cancel("1")
+				d.cancel("3")
+			},
+			due: []dueAt{
+				{after: time.Hour, want: []string{"2 start"}},
+			},
+		},
+		{
+			name: "same instant fires in schedule order",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				for _, id := range []string{"3", "1", "2", "5", "4"} {
+					d.schedule(id, startTimer, start.Add(time.Second), fire(id+" start"))
+				}
+				d.schedule("3", stopTimer, start.Add(time.Second), fire("3 stop"))
+			},
+			due: []dueAt{
+				{after: time.Second, want: []string{"3 start", "1 start", "2 start", "5 start", "4 start", "3 stop"}},
+			},
+		},
+		{
+			name: "rescheduled to the same instant fires last",
+			schedule: func(d *dispatcher, fire func(string) func()) {
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 start"))
+				d.schedule("2", startTimer, start.Add(time.Second), fire("2 start"))
+				d.schedule("1", startTimer, start.Add(time.Second), fire("1 rescheduled"))
+			},
+			due: []dueAt{
+				{after: time.Second, want: []string{"2 start", "1 rescheduled"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			d := newDispatcher()
+			d.now = func() time.Time { return now }
+
+			var fired []string
+			tt.schedule(d, func(name string) func() {
+				return func() { fired = append(fired, name) }
+			})
+
+			for _, due := range tt.due {
+				now = start.Add(due.after)
+				fired = nil
+				for _, fire := range d.due(d.now()) {
+					fire()
+				}
+				if !slices.Equal(fired, due.want) {
+					t.Fatalf("after %s: got %v, want %v", due.after, fired, due.want)
+				}
+			}
+			if len(d.timers) != 0 || len(d.keys) != 0 {
+				t.Fatalf("got %d timers and %d keys left", len(d.timers), len(d.keys))
+			}
+		})
+	}
+}
+
+func TestDispatcherUntilNext(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := newDispatcher()
+	d.now = func() time.Time { return now }
+
+	if got := d.untilNext(); got != time.Hour {
+		t.Fatalf("no timer: got %s, want %s", got, time.Hour)
+	}
+
+	d.schedule("1", startTimer, now.Add(90*time.Second), func() {})
+	if got := d.untilNext(); got != 90*time.Second {
+		t.Fatalf("got %s, want %s", got, 90*time.Second)
+	}
+
+	// an overdue timer is due right away
+	now = now.Add(2 * time.Minute)
+	if got := d.untilNext(); got != 0 {
+		t.Fatalf("overdue timer: got %s, want 0", got)
+	}
+}
@@ -0,0 +1,239 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/maacarma/scheduler/pkg/metrics"
+	svc "github.com/maacarma/scheduler/pkg/services/tasks"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	stillRunning   = "previous run is still running"
+	alreadyQueue   = "a run is already waiting for the previous run"
+	uncountedRun   = "unable to count the run towards max runs"
+	maxRunsReached = "max runs reached"
+)
+
+// job is the cron job of a task.
+// It tracks the fire time intended by the cron, so that the executions
+// are recorded against the time they were scheduled at rather than the time they ran.
+//
+// It also applies the task's concurrency policy when a run fires
+// while the previous one is still running.
+type job struct {
+	ctx      context.Context
+	executor *svc.Executor
+	schedule cron.Schedule
+	policy   string
+	next     time.Time
+	mu       sync.Mutex
+
+	// running holds a token while a run is in-flight (skip, queue policies)
+	running chan struct{}
+	// waiting holds a token while a run waits for the in-flight one (queue policy)
+	waiting chan struct{}
+	// cancelRunning cancels the in-flight run and done is closed
+	// when it returns (replace policy)
+	cancelRunning context.CancelFunc
+	done          chan struct{}
+
+	// skipped is the count of runs skipped by the concurrency policy
+	skipped atomic.Int64
+
+	// limit limits the runs to the task's max runs, nil if unlimited
+	limit *runLimit
+	// fired is called with the fire time of every run, before it is run
+	fired func(scheduledAt time.Time)
+	// runs tracks the runs of the job in-flight, the runs fired once stopped are dropped
+	runs *runs
+}
+
+// runLimit limits the runs of a job to the task's max runs.
+// The run count is persisted, so that the limit holds across restarts.
+type runLimit struct {
+	max int64
+	// count increments the persisted run count and returns it
+	count func(ctx context.Context) (int64, error)
+	// reached is called once the last run and the runs in-flight alongside it return
+	reached func()
+
+	mu       sync.Mutex
+	inflight int
+	// exhausted is set once the last run is counted
+	exhausted bool
+	done      bool
+}
+
+// begin tracks a run in-flight.
+func (l *runLimit) begin() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight++
+}
+
+// end tracks the return of a run counted as n,
+// it returns true only once, when the limit is reached and no run is in-flight.
+func (l *runLimit) end(n int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.exhausted = l.exhausted || n >= l.max
+	if !l.exhausted || l.inflight > 0 || l.done {
+		return false
+	}
+
+	l.done = true
+	return true
+}
+
+// newJob creates a job, the first fire time is computed the same way cron does
+// when the job is added. the executions are cancelled when the ctx is done.
+func newJob(ctx context.Context, executor *svc.Executor, schedule cron.Schedule, policy string) *job {
+	return &job{
+		ctx:      ctx,
+		executor: executor,
+		schedule: schedule,
+		policy:   policy,
+		next:     schedule.Next(time.Now().UTC()),
+		running:  make(chan struct{}, 1),
+		waiting:  make(chan struct{}, 1),
+	}
+}
+
+// Run runs the executor for the intended fire time.
+// This method is used by the cron to execute tasks.
+func (j *job) Run() {
+	j.mu.Lock()
+	scheduledAt := j.next
+	j.next = j.schedule.Next(time.Now().UTC())
+	j.mu.Unlock()
+
+	metrics.ObserveScheduleLag(j.executor.Namespace(), time.Since(scheduledAt))
+	j.run(scheduledAt)
+}
+
+// RunNow runs the executor immediately, outside of the cron.
+func (j *job) RunNow() {
+	j.RunAt(time.Now().UTC())
+}
+
+// RunAt runs the executor immediately for the given fire time, outside of the cron.
+func (j *job) RunAt(scheduledAt time.Time) {
+	j.run(scheduledAt)
+}
+
+// Skipped returns the count of runs skipped by the concurrency policy.
+func (j *job) Skipped() int64 {
+	return j.skipped.Load()
+}
+
+// run runs the executor as per the concurrency policy.
+func (j *job) run(scheduledAt time.Time) {
+	if j.runs != nil {
+		if !j.runs.begin() {
+			return
+		}
+		defer j.runs.end()
+	}
+
+	if j.fired != nil {
+		j.fired(scheduledAt)
+	}
+
+	switch j.policy {
+	case models.ConcurrencySkip:
+		select {
+		case j.running <- struct{}{}:
+			defer func() { <-j.running }()
+		default:
+			j.skip(scheduledAt, stillRunning)
+			return
+		}
+
+	case models.ConcurrencyQueue:
+		select {
+		case j.waiting <- struct{}{}:
+		default:
+			j.skip(scheduledAt, alreadyQueue)
+			return
+		}
+
+		select {
+		case j.running <- struct{}{}:
+			<-j.waiting
+			defer func() { <-j.running }()
+		case <-j.ctx.Done():
+			<-j.waiting
+			return
+		}
+
+	case models.ConcurrencyReplace:
+		ctx, finish := j.replace()
+		defer finish()
+		j.execute(ctx, scheduledAt)
+		return
+	}
+
+	j.execute(j.ctx, scheduledAt)
+}
+
+// execute runs the executor, counting the run towards the max runs if limited.
+// the runs fired after the limit is reached are skipped.
+func (j *job) execute(ctx context.Context, scheduledAt time.Time) {
+	if j.limit == nil {
+		j.executor.Run(ctx, scheduledAt)
+		return
+	}
+
+	j.limit.begin()
+	n, err := j.limit.count(j.ctx)
+	switch {
+	case err != nil:
+		j.executor.Skip(j.ctx, scheduledAt, uncountedRun)
+	case n > j.limit.max:
+		j.executor.Skip(j.ctx, scheduledAt, maxRunsReached)
+	default:
+		j.executor.Run(ctx, scheduledAt)
+	}
+
+	if j.limit.end(n) {
+		j.limit.reached()
+	}
+}
+
+// replace cancels the in-flight run and waits for it to return.
+// It returns the context of the new run and the func to call when it returns.
+func (j *job) replace() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(j.ctx)
+	done := make(chan struct{})
+
+	j.mu.Lock()
+	if j.cancelRunning != nil {
+		j.cancelRunning()
+	}
+	previous := j.done
+	j.cancelRunning, j.done = cancel, done
+	j.mu.Unlock()
+
+	if previous != nil {
+		<-previous
+	}
+
+	finish := func() {
+		cancel()
+		close(done)
+	}
+	return ctx, finish
+}
+
+// skip records the run as skipped.
+func (j *job) skip(scheduledAt time.Time, reason string) {
+	j.skipped.Add(1)
+	j.executor.Skip(j.ctx, scheduledAt, reason)
+}
@@ -0,0 +1,152 @@
+package schedule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	svc "github.com/maacarma/scheduler/pkg/services/tasks"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+func TestConcurrencyPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		// overlap fires the runs overlapping the first one, which is in-flight
+		overlap func(t *testing.T, j *job, fire func())
+		want    []string
+	}{
+		{
+			policy: models.ConcurrencySkip,
+			overlap: func(t *testing.T, j *job, fire func()) {
+				// the skipped run returns right away
+				j.RunAt(time.Now().UTC())
+			},
+			want: []string{models.ExecutionSucceeded, models.ExecutionSkipped},
+		},
+		{
+			policy: models.ConcurrencyQueue,
+			overlap: func(t *testing.T, j *job, fire func()) {
+				fire()
+				waitFor(t, func() bool { return len(j.waiting) == 1 })
+				// only one run waits for the in-flight one
+				j.RunAt(time.Now().UTC())
+			},
+			want: []string{models.ExecutionSucceeded, models.ExecutionSkipped, models.ExecutionSucceeded},
+		},
+		{
+			policy: models.ConcurrencyReplace,
+			overlap: func(t *testing.T, j *job, fire func()) {
+				fire()
+			},
+			want: []string{models.ExecutionCancelled, models.ExecutionSucceeded},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			// the requests are held until released or cancelled
+			started := make(chan struct{}, 3)
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				started <- struct{}{}
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}))
+			defer server.Close()
+
+			executions := &fakeExecutions{}
+			task := &models.Task{ID: "1", Url: server.URL, Method: http.MethodGet}
+			executor := svc.NewExecutor(task, executions, testConfig(), zap.NewNop())
+			j := newJob(context.Background(), executor, cron.Every(time.Hour), tt.policy)
+
+			var wg sync.WaitGroup
+			fire := func() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					j.RunAt(time.Now().UTC())
+				}()
+			}
+
+			fire()
+			<-started
+			tt.overlap(t, j, fire)
+			if tt.policy == models.ConcurrencyReplace {
+				// the replacing run starts once the first one is cancelled
+				<-started
+			}
+			close(release)
+			wg.Wait()
+
+			if got := executions.statuses(); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			skipped := int64(0)
+			for _, status := range tt.want {
+				if status == models.ExecutionSkipped {
+					skipped++
+				}
+			}
+			if j.Skipped() != skipped {
+				t.Fatalf("got %d skipped runs, want %d", j.Skipped(), skipped)
+			}
+		})
+	}
+}
+
+func TestQueuedRunFollowsTheRunningOne(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	executions := &fakeExecutions{}
+	task := &models.Task{ID: "1", Url: server.URL, Method: http.MethodGet}
+	executor := svc.NewExecutor(task, executions, testConfig(), zap.NewNop())
+	j := newJob(context.Background(), executor, cron.Every(time.Hour), models.ConcurrencyQueue)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.RunAt(time.Now().UTC())
+		}()
+	}
+	waitFor(t, func() bool { return len(j.running) == 1 && len(j.waiting) == 1 })
+	close(release)
+	wg.Wait()
+
+	executions.mu.Lock()
+	defer executions.mu.Unlock()
+	if len(executions.executions) != 2 {
+		t.Fatalf("got %d executions, want 2", len(executions.executions))
+	}
+	first, second := executions.executions[0], executions.executions[1]
+	if second.StartedAt.Before(first.EndedAt) {
+		t.Fatalf("the queued run started at %s, before the running one ended at %s", second.StartedAt, first.EndedAt)
+	}
+}
+
+// waitFor waits for the condition to hold.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
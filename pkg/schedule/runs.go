@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+)
+
+// runs tracks the runs in-flight, so that the scheduler drains them when stopped.
+// no run begins once stopped.
+type runs struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+// begin tracks a run in-flight, it returns false if stopped.
+func (r *runs) begin() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return false
+	}
+
+	r.wg.Add(1)
+	return true
+}
+
+// end tracks the return of a run.
+func (r *runs) end() {
+	r.wg.Done()
+}
+
+// stop stops the runs from beginning.
+func (r *runs) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
+
+// wait waits for the runs in-flight to return, it returns false if the ctx is done first.
+func (r *runs) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
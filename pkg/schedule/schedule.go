@@ -2,12 +2,15 @@ package schedule
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/maacarma/scheduler/config"
 	db "github.com/maacarma/scheduler/pkg/db"
+	encryption "github.com/maacarma/scheduler/pkg/encryption"
+	metrics "github.com/maacarma/scheduler/pkg/metrics"
 	svc "github.com/maacarma/scheduler/pkg/services/tasks"
 	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
 	mongodb "github.com/maacarma/scheduler/pkg/services/tasks/store/mongodb"
@@ -15,6 +18,7 @@ import (
 	utils "github.com/maacarma/scheduler/utils"
 
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -28,137 +32,549 @@ const (
 	noTaskFound                = "no task found with id: %s"
 	noActiveTaskFoundToDiscard = "no active task found with id: %s to discard"
 	unableToScheduleTask       = "unable to schedule task with id: %s due to %v"
+	completedTask              = "task with id: %s completed"
+	unableToCompleteTask       = "unable to mark task with id: %s completed due to %v"
+	unableToRecordFire         = "unable to record the fire time of task with id: %s due to %v"
+	unableToCatchUp            = "unable to catch up the missed runs of task with id: %s due to %v"
+	catchingUp                 = "catching up %d missed runs of task with id: %s"
+	inactiveScheduler          = "scheduler isn't active to schedule task with id: %s"
+	notOwnedTask               = "task with id: %s belongs to another shard"
+	skippedTask                = "skipped an active task which can't be read"
+	resyncedScheduler          = "resynced the scheduler with the database"
+	rebalancedScheduler        = "rebalanced the shard of the scheduler"
+	suspendedScheduler         = "suspended the scheduler, discarded all tasks"
+	stoppedScheduler           = "scheduler is stopped"
+	drainingScheduler          = "stopping the scheduler, draining the in-flight executions"
+	interruptingExecutions     = "drain timeout exceeded, cancelling the in-flight executions"
+	undrainedScheduler         = "stopped the scheduler before the cancelled executions were recorded"
+	drainedScheduler           = "stopped the scheduler, drained the in-flight executions"
+	// interruptTimeout is the time given to the cancelled executions to be recorded.
+	interruptTimeout = 10 * time.Second
+	// defaultDrainTimeout is the drain timeout of a config which doesn't set one.
+	defaultDrainTimeout = 30 * time.Second
 )
 
 type repo interface {
-	// returns unexpired and unpaused tasks
-	GetActiveTasks(ctx context.Context, curUnix utils.Unix) ([]*models.Task, error)
+	// returns unexpired, unpaused and uncompleted tasks of the shard,
+	// the tasks which can't be read are skipped and reported by a models.SkippedTasksError
+	GetActiveTasks(ctx context.Context, curUnix utils.Unix, shard models.Shard) ([]*models.Task, error)
+	// marks the task completed, so that it isn't scheduled anymore
+	Complete(ctx context.Context, id string) error
+	// atomically increments the run count of the task and returns it
+	IncrementRunCount(ctx context.Context, id string) (int64, error)
+	// updates the last scheduled fire time of the task
+	UpdateLastFire(ctx context.Context, id string, fireUnix int64) error
 }
 
-type tasksMap map[string]cron.EntryID
+// entry is a task scheduled in the cron.
+// cancel cancels the in-flight executions of the task.
+// one-shot tasks aren't added to the cron, their id is zero.
+type entry struct {
+	id     cron.EntryID
+	job    *job
+	task   *models.Task
+	cancel context.CancelFunc
+}
+
+type tasksMap map[string]entry
+
+// pendingMap holds the tasks waiting for their start time to be added to the cron.
+type pendingMap map[string]*models.Task
 
 type Scheduler struct {
-	repo    repo
-	cron    *cron.Cron
-	tasks   tasksMap
+	// ctx is the parent of the tasks contexts, the in-flight executions
+	// are cancelled when it is done, cancel is called by Stop once the drain timeout exceeds.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	repo       repo
+	executions svc.ExecutionRepo
+	cron       *cron.Cron
+	tasks      tasksMap
+	pending    pendingMap
+	replays    tasksMap
+	// timers fires the start times of the pending tasks and the end times of the scheduled ones
+	timers  *dispatcher
 	tasksMu sync.Mutex
-	conf    *config.Config
-	logger  *zap.Logger
+	// active is set while the scheduler is started,
+	// an inactive scheduler doesn't schedule the tasks (Ex: a replica which isn't the leader).
+	active bool
+	// shard is the shard of tasks scheduled by the scheduler, nil schedules every task.
+	shard models.Shard
+	// loaded is set once the active tasks are loaded since started.
+	loaded bool
+	// stopped is set once the scheduler is stopped, it can't be started again.
+	stopped bool
+	// runs tracks the in-flight runs, drained by Stop.
+	runs   runs
+	conf   *config.Config
+	logger *zap.Logger
 }
 
 // New creates a new scheduler instance.
-// TODO: convert all the go-routines into effectiet using Select & For channel
-func New(ctx context.Context, conf *config.Config, logger *zap.Logger) (*Scheduler, error) {
-	dbClients, err := db.Connect(ctx, conf)
+// the in-flight executions outlive the ctx, so that they are drained by Stop.
+// the database clients are shared with the other components, they are closed by the caller.
+func New(ctx context.Context, dbClients *db.Clients, conf *config.Config, logger *zap.Logger) (*Scheduler, error) {
+	keyring, err := encryption.FromConfig(conf)
 	if err != nil {
 		return nil, err
 	}
 
 	var repo repo
+	var executions svc.ExecutionRepo
 	switch {
 	case dbClients.Pg != nil:
-		repo = postgres.New(dbClients.Pg)
+		repo = postgres.New(dbClients.Pg, keyring)
+		executions = postgres.NewExecutions(dbClients.Pg)
 	case dbClients.Mongo != nil:
-		repo = mongodb.New(dbClients.Mongo)
+		repo = mongodb.New(dbClients.Mongo, keyring)
+		executions, err = mongodb.NewExecutions(ctx, dbClients.Mongo)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	s := newScheduler(ctx, repo, executions, conf, logger)
+	metrics.RegisterScheduledTasks(s.scheduledCount)
+
+	return s, nil
+}
+
+// newScheduler creates a scheduler of the tasks of the repos.
+func newScheduler(ctx context.Context, repo repo, executions svc.ExecutionRepo, conf *config.Config, logger *zap.Logger) *Scheduler {
 	cron := cron.New(cron.WithLocation(time.UTC))
 	tasks := make(tasksMap)
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	timers := newDispatcher()
+	go timers.run(runCtx)
 
 	return &Scheduler{
-		repo:   repo,
-		cron:   cron,
-		tasks:  tasks,
-		conf:   conf,
-		logger: logger,
-	}, nil
+		ctx:        runCtx,
+		cancel:     cancel,
+		repo:       repo,
+		executions: executions,
+		cron:       cron,
+		tasks:      tasks,
+		pending:    make(pendingMap),
+		replays:    make(tasksMap),
+		timers:     timers,
+		conf:       conf,
+		logger:     logger,
+	}
+}
+
+// Running checks if the scheduler is started, neither suspended nor stopped.
+func (s *Scheduler) Running() bool {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	return s.active
+}
+
+// Loaded checks if the active tasks were loaded since the scheduler started.
+func (s *Scheduler) Loaded() bool {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	return s.loaded
+}
+
+// scheduledCount returns the count of the tasks scheduled in the cron.
+func (s *Scheduler) scheduledCount() int {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	return len(s.tasks)
 }
 
 // Start starts the scheduler.
-// It schedules all the active tasks that read from the database.
+// It schedules all the active tasks that read from the database,
+// catching up the runs missed while the scheduler was down as per their misfire policy.
 func (s *Scheduler) Start(ctx context.Context) error {
-	curUnix := utils.CurrentUTCUnix()
-	tasks, err := s.repo.GetActiveTasks(ctx, curUnix)
+	s.tasksMu.Lock()
+	if s.stopped {
+		s.tasksMu.Unlock()
+		return fmt.Errorf(stoppedScheduler)
+	}
+	s.active = true
+	s.tasksMu.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		s.tasksMu.Lock()
+		s.active = false
+		s.tasksMu.Unlock()
+		return err
+	}
+
+	s.tasksMu.Lock()
+	s.loaded = true
+	s.tasksMu.Unlock()
+	s.cron.Start()
+	s.logger.Info(scheduleSuccess)
+	return nil
+}
+
+// Suspend stops the scheduler and discards all the tasks, cancelling their in-flight executions.
+// the tasks are scheduled again when the scheduler is started.
+func (s *Scheduler) Suspend() {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	s.active = false
+	s.loaded = false
+	s.cron.Stop()
+	s.discardTasks(func(string) bool { return true })
+
+	s.logger.Warn(suspendedScheduler)
+}
+
+// Stop stops the scheduler gracefully, the tasks aren't fired anymore and the in-flight
+// executions are waited for up to the drain timeout of the config or until the ctx is done.
+// A zero drain timeout falls back to 30 seconds, so that an unset timeout doesn't cancel the executions right away.
+// The executions still in-flight afterwards are cancelled and recorded as cancelled.
+// The timers of the scheduler are stopped, it can't be started again.
+// The database connections are owned by the caller, which closes them once the scheduler is stopped.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.tasksMu.Lock()
+	s.active = false
+	s.stopped = true
+	s.tasksMu.Unlock()
+
+	s.runs.stop()
+	s.cron.Stop()
+	s.logger.Info(drainingScheduler)
+	defer s.close()
+
+	drainTimeout := s.conf.Scheduler.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	if s.runs.wait(drainCtx) {
+		s.logger.Info(drainedScheduler)
+		return nil
+	}
+
+	s.logger.Warn(interruptingExecutions)
+	s.cancel()
+	recordCtx, cancelRecord := context.WithTimeout(context.Background(), interruptTimeout)
+	defer cancelRecord()
+	if !s.runs.wait(recordCtx) {
+		return fmt.Errorf(undrainedScheduler)
+	}
+
+	s.logger.Info(drainedScheduler)
+	return nil
+}
+
+// close stops the timers of the scheduler.
+func (s *Scheduler) close() {
+	s.cancel()
+}
+
+// Rebalance changes the shard of the scheduler, the tasks which left the shard are discarded
+// and the active tasks which joined it are scheduled. an inactive scheduler is started.
+func (s *Scheduler) Rebalance(ctx context.Context, shard models.Shard) error {
+	s.tasksMu.Lock()
+	s.shard = shard
+	s.discardTasks(func(taskID string) bool { return !shard.Owns(taskID) })
+	active := s.active
+	s.tasksMu.Unlock()
+
+	if !active {
+		return s.Start(ctx)
+	}
+
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+
+	s.logger.Info(rebalancedScheduler)
+	return nil
+}
+
+// load schedules the active tasks of the shard which aren't scheduled yet,
+// catching up the runs missed while they weren't scheduled as per their misfire policy.
+func (s *Scheduler) load(ctx context.Context) error {
+	s.tasksMu.Lock()
+	shard := s.shard
+	s.tasksMu.Unlock()
+
+	tasks, err := s.activeTasks(ctx, shard)
 	if err != nil {
 		return fmt.Errorf(scheduleErr, err)
 	}
 
 	for _, t := range tasks {
+		if s.isScheduled(t.ID) {
+			continue
+		}
+
+		s.catchUp(t)
 		s.ScheduleTask(t)
 	}
 
-	s.cron.Start()
-	s.logger.Info(scheduleSuccess)
 	return nil
 }
 
+// activeTasks returns the active tasks of the shard,
+// the tasks which can't be read (Ex: a corrupted secret) are logged and skipped.
+func (s *Scheduler) activeTasks(ctx context.Context, shard models.Shard) ([]*models.Task, error) {
+	tasks, err := s.repo.GetActiveTasks(ctx, utils.CurrentUTCUnix(), shard)
+	var skipped *models.SkippedTasksError
+	if errors.As(err, &skipped) {
+		for _, err := range skipped.Errs {
+			s.logger.Error(skippedTask, zap.Error(err))
+		}
+		return tasks, nil
+	}
+
+	return tasks, err
+}
+
+// isScheduled checks if the task is scheduled, pending or replaying in the scheduler.
+func (s *Scheduler) isScheduled(taskID string) bool {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	_, scheduled := s.tasks[taskID]
+	_, pending := s.pending[taskID]
+	_, replaying := s.replays[taskID]
+	return scheduled || pending || replaying
+}
+
+// discardTasks discards the scheduled, pending and replaying tasks matching the discard func.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) discardTasks(discard func(taskID string) bool) {
+	for _, ids := range [][]string{utils.Keys(s.tasks), utils.Keys(s.pending), utils.Keys(s.replays)} {
+		for _, taskID := range ids {
+			if discard(taskID) {
+				s.discardTask(taskID)
+			}
+		}
+	}
+}
+
 // ScheduleTask schedules the task based on the start time.
 func (s *Scheduler) ScheduleTask(t *models.Task) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	s.scheduleTask(t)
+}
+
+// RescheduleTask replaces the scheduled task with its new definition.
+// The old definition is discarded and the new one scheduled under the same lock,
+// so that no other schedule or discard of the task interleaves.
+// A paused task is only discarded.
+func (s *Scheduler) RescheduleTask(t *models.Task) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	s.discardTask(t.ID)
+	if !t.Paused {
+		s.scheduleTask(t)
+	}
+}
+
+// ApplyTask applies a change of the task made elsewhere (Ex: by another replica) to the scheduler.
+// The task is left as is if it is scheduled with the same definition,
+// so that a change made by this replica isn't applied twice. A paused task is discarded.
+func (s *Scheduler) ApplyTask(t *models.Task) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	if scheduled := s.scheduledTask(t.ID); scheduled != nil && scheduled.SameDefinition(t) {
+		return
+	}
+
+	s.discardTask(t.ID)
+	if !t.Paused {
+		s.scheduleTask(t)
+	}
+}
+
+// Resync reconciles the scheduler with the active tasks of the database,
+// the changes missed while not watching the database (Ex: while reconnecting) are applied.
+// the tasks which are no more active are discarded, except the ones scheduled during the resync.
+func (s *Scheduler) Resync(ctx context.Context) error {
+	s.tasksMu.Lock()
+	active, shard := s.active, s.shard
+	before := make(map[string]bool, len(s.tasks)+len(s.pending))
+	for _, ids := range [][]string{utils.Keys(s.tasks), utils.Keys(s.pending)} {
+		for _, taskID := range ids {
+			before[taskID] = true
+		}
+	}
+	s.tasksMu.Unlock()
+
+	if !active {
+		return nil
+	}
+
+	tasks, err := s.activeTasks(ctx, shard)
+	if err != nil {
+		return fmt.Errorf(scheduleErr, err)
+	}
+
+	current := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		current[t.ID] = true
+		s.ApplyTask(t)
+	}
+
+	s.tasksMu.Lock()
+	s.discardTasks(func(taskID string) bool { return before[taskID] && !current[taskID] })
+	s.tasksMu.Unlock()
+
+	s.logger.Info(resyncedScheduler)
+	return nil
+}
+
+// scheduledTask returns the definition of the task scheduled or pending, nil if neither.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) scheduledTask(taskID string) *models.Task {
+	if entry, exists := s.tasks[taskID]; exists {
+		return entry.task
+	}
+
+	return s.pending[taskID]
+}
+
+// scheduleTask schedules the task based on the start time.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) scheduleTask(t *models.Task) {
+	if t.Completed || !s.active || !s.shard.Owns(t.ID) {
+		return
+	}
+
+	// the last run returned but the task wasn't marked completed
+	if t.RunsExhausted() {
+		go s.completeTask(t.ID)
+		return
+	}
+
 	curUnix := utils.CurrentUTCUnix()
 	startUnix := utils.Unix(t.StartUnix)
 
 	if curUnix == startUnix {
-		s.ScheduleTaskNow(t)
+		if err := s.scheduleTaskNow(t); err != nil {
+			s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
+		}
 	} else if curUnix < startUnix {
-		go s.scheduleTaskWithDelay(startUnix.Sub(curUnix, false), t)
+		s.scheduleTaskAfter(startUnix.Sub(curUnix, false), t)
 	} else {
 		s.scheduleExistingTask(t)
 	}
 }
 
 // ScheduleTaskNow adds the task to the cron.
-// Runs the task immediately because cron/v3 doesn't support immediate scheduling.
+// Runs an interval task immediately because cron/v3 doesn't support immediate scheduling,
+// cron tasks are left to fire on their next matching time.
 // and also triggers a goroutine to discard the task after the end time.
 
 // It returns an error if the task is already scheduled.
 func (s *Scheduler) ScheduleTaskNow(t *models.Task) error {
-	endUnix := utils.Unix(t.EndUnix)
-	curUnix := utils.CurrentUTCUnix()
-
 	s.tasksMu.Lock()
 	defer s.tasksMu.Unlock()
+	return s.scheduleTaskNow(t)
+}
+
+// scheduleTaskNow adds the task to the cron.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) scheduleTaskNow(t *models.Task) error {
+	endUnix := utils.Unix(t.EndUnix)
+
+	if !s.active {
+		return fmt.Errorf(inactiveScheduler, t.ID)
+	}
+
+	if !s.shard.Owns(t.ID) {
+		return fmt.Errorf(notOwnedTask, t.ID)
+	}
+
 	if _, exists := s.tasks[t.ID]; exists {
 		return fmt.Errorf(duplicateTask, t.ID)
 	}
 
-	executor := svc.NewExecutor(t, s.logger)
-	updatedInterval := utils.ConvertToCronInterval(t.Interval)
-	// runs the task in separate goroutine, this shouldn't be blocking
-	go executor.Run()
-	entryID, err := s.cron.AddJob(updatedInterval, executor)
+	schedule, err := t.Schedule()
 	if err != nil {
 		return fmt.Errorf(unableToScheduleTask, t.ID, err)
 	}
 
-	s.tasks[t.ID] = entryID
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := s.taskJob(ctx, t, schedule)
+	if t.IsOneShot() {
+		s.tasks[t.ID] = entry{job: job, task: t, cancel: cancel}
+		go s.runOnce(t, job)
+		return nil
+	}
+
+	if !t.IsCron() {
+		// runs the task in separate goroutine, this shouldn't be blocking
+		go job.RunNow()
+	}
+	entryID := s.cron.Schedule(schedule, job)
+
+	s.tasks[t.ID] = entry{id: entryID, job: job, task: t, cancel: cancel}
 	deleteBuffer := time.Second
-	deletesIn := endUnix.Sub(curUnix, false) + deleteBuffer
-	go s.discardTaskWithDelay(deletesIn, t.ID)
+	deletesAt := time.Unix(int64(endUnix), 0).Add(deleteBuffer)
+	s.timers.schedule(t.ID, stopTimer, deletesAt, func() { s.expireTask(t.ID, entryID) })
 
 	return nil
 }
 
-// scheduleTaskWithDelay schedules the task after the duration.
-// calls ScheduleTaskNow after the duration.
-func (s *Scheduler) scheduleTaskWithDelay(duration time.Duration, t *models.Task) {
-	ticker := time.NewTicker(duration)
+// taskJob creates the job of the task, which records its fire times
+// and counts its runs towards the max runs of the task.
+func (s *Scheduler) taskJob(ctx context.Context, t *models.Task, schedule cron.Schedule) *job {
+	executor := svc.NewExecutor(t, s.executions, s.conf, s.logger)
+	job := newJob(ctx, executor, schedule, t.ConcurrencyPolicy)
+	job.runs = &s.runs
+	job.fired = func(scheduledAt time.Time) {
+		if err := s.repo.UpdateLastFire(ctx, t.ID, scheduledAt.Unix()); err != nil {
+			s.logger.Error(fmt.Sprintf(unableToRecordFire, t.ID, err))
+		}
+	}
 
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			err := s.ScheduleTaskNow(t)
-			if err != nil {
-				s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
-			}
-			s.logger.Info(fmt.Sprintf(scheduledTask, t.ID))
-			return
+	if t.MaxRuns > 0 && !t.IsOneShot() {
+		job.limit = &runLimit{
+			max: t.MaxRuns,
+			count: func(ctx context.Context) (int64, error) {
+				return s.repo.IncrementRunCount(ctx, t.ID)
+			},
+			reached: func() { s.finishTask(t.ID, job) },
 		}
 	}
+
+	return job
+}
+
+// scheduleTaskAfter registers the task as pending and schedules it after the duration.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) scheduleTaskAfter(duration time.Duration, t *models.Task) {
+	s.pending[t.ID] = t
+	s.timers.schedule(t.ID, startTimer, time.Now().Add(duration), func() { s.startTask(t) })
+}
+
+// startTask adds the pending task to the cron at its start time,
+// unless the task was discarded or rescheduled with a new definition in the meantime.
+func (s *Scheduler) startTask(t *models.Task) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	if s.pending[t.ID] != t {
+		return
+	}
+	delete(s.pending, t.ID)
+
+	err := s.scheduleTaskNow(t)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
+		return
+	}
+	s.logger.Info(fmt.Sprintf(scheduledTask, t.ID))
 }
 
 // scheduleExistingTask schedules the existing task.
 // it calculates the next recur time and then adds to the cron.
+// cron tasks are added right away if they have a fire time before the end time,
+// since cron computes their next fire time from the spec.
+// the caller must hold the tasksMu lock.
 //
 // beware: panics if the task.StartUnix is greater than the current time.
 func (s *Scheduler) scheduleExistingTask(t *models.Task) {
@@ -166,6 +582,29 @@ func (s *Scheduler) scheduleExistingTask(t *models.Task) {
 	endUnix := utils.Unix(t.EndUnix)
 	curUnix := utils.CurrentUTCUnix()
 
+	if t.IsOneShot() {
+		s.scheduleMissedTask(t)
+		return
+	}
+
+	if t.IsCron() {
+		schedule, err := t.Schedule()
+		if err != nil {
+			s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
+			return
+		}
+
+		next := schedule.Next(time.Now().UTC())
+		if next.IsZero() || utils.Unix(next.Unix()) > endUnix {
+			return
+		}
+
+		if err := s.scheduleTaskNow(t); err != nil {
+			s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
+		}
+		return
+	}
+
 	// parsing the interval
 	interval, _ := time.ParseDuration(t.Interval)
 	updatedInterval := cron.Every(interval).Delay
@@ -177,18 +616,217 @@ func (s *Scheduler) scheduleExistingTask(t *models.Task) {
 		return
 	}
 
-	go s.scheduleTaskWithDelay(nextTriggerIn, t)
+	s.scheduleTaskAfter(nextTriggerIn, t)
+}
+
+// scheduleMissedTask handles a one-shot task whose start time passed while
+// the scheduler was down, as per the task's misfire policy.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) scheduleMissedTask(t *models.Task) {
+	if t.MisfirePolicy == models.MisfireSkip {
+		go s.missTask(t)
+		return
+	}
+
+	if err := s.scheduleTaskNow(t); err != nil {
+		s.logger.Error(fmt.Sprintf(unableToScheduleTask, t.ID, err))
+	}
+}
+
+// catchUp runs the fire times of a recurring task missed while the scheduler was down,
+// as per the task's misfire policy. the missed one-shot tasks are handled when scheduled.
+//
+// fire_once runs the task once now, replay_all runs the missed fire times in order
+// up to the max replays of the config, alongside the schedule of the task.
+// the missed fire times are counted in the metrics up to the max replays as well.
+func (s *Scheduler) catchUp(t *models.Task) {
+	if t.IsOneShot() || t.Completed || t.RunsExhausted() {
+		return
+	}
+
+	now := time.Now().UTC()
+	missed, err := t.MissedRuns(now, max(s.conf.Scheduler.MaxReplays, 1))
+	if err != nil {
+		s.logger.Error(fmt.Sprintf(unableToCatchUp, t.ID, err))
+		return
+	}
+	if len(missed) == 0 {
+		return
+	}
+	metrics.ObserveMissedFires(t.Namespace, len(missed))
+
+	switch t.MisfirePolicy {
+	case models.MisfireFireOnce:
+		missed = []time.Time{now}
+	case models.MisfireReplayAll:
+		if s.conf.Scheduler.MaxReplays == 0 {
+			return
+		}
+	default:
+		return
+	}
+
+	schedule, err := t.Schedule()
+	if err != nil {
+		s.logger.Error(fmt.Sprintf(unableToCatchUp, t.ID, err))
+		return
+	}
+
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := s.taskJob(ctx, t, schedule)
+	s.replays[t.ID] = entry{job: job, task: t, cancel: cancel}
+
+	s.logger.Info(fmt.Sprintf(catchingUp, len(missed), t.ID))
+	go s.replay(t.ID, job, missed)
+}
+
+// replay runs the job for the missed fire times one after the other,
+// unless the task is discarded in the meantime.
+func (s *Scheduler) replay(taskID string, job *job, missed []time.Time) {
+	for _, scheduledAt := range missed {
+		if job.ctx.Err() != nil {
+			break
+		}
+		job.RunAt(scheduledAt)
+	}
+
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	if replay, exists := s.replays[taskID]; exists && replay.job == job {
+		replay.cancel()
+		delete(s.replays, taskID)
+	}
+}
+
+// runOnce runs the one-shot task for its start time and marks it completed,
+// unless the task was discarded in the meantime.
+func (s *Scheduler) runOnce(t *models.Task, job *job) {
+	job.RunAt(time.Unix(t.StartUnix, 0).UTC())
+	if job.ctx.Err() != nil {
+		return
+	}
+
+	s.finishTask(t.ID, job)
+}
+
+// finishTask marks the task completed and discards it from the scheduler,
+// unless it was rescheduled with a new job in the meantime.
+func (s *Scheduler) finishTask(taskID string, job *job) {
+	s.completeTask(taskID)
+
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	if entry, exists := s.tasks[taskID]; exists && entry.job == job {
+		s.discardTask(taskID)
+	}
+}
+
+// missTask records the missed run of the one-shot task and marks it completed.
+func (s *Scheduler) missTask(t *models.Task) {
+	if !s.runs.begin() {
+		return
+	}
+	defer s.runs.end()
+
+	executor := svc.NewExecutor(t, s.executions, s.conf, s.logger)
+	executor.Miss(s.ctx, time.Unix(t.StartUnix, 0).UTC())
+	s.completeTask(t.ID)
+}
+
+// completeTask marks the task completed in the database.
+func (s *Scheduler) completeTask(taskID string) {
+	if err := s.repo.Complete(s.ctx, taskID); err != nil {
+		s.logger.Error(fmt.Sprintf(unableToCompleteTask, taskID, err))
+		return
+	}
+
+	s.logger.Info(fmt.Sprintf(completedTask, taskID))
+}
+
+// TaskState returns the runtime state of the task in this scheduler.
+func (s *Scheduler) TaskState(taskID string) models.TaskState {
+	s.tasksMu.Lock()
+	entry, scheduled := s.tasks[taskID]
+	_, pending := s.pending[taskID]
+	s.tasksMu.Unlock()
+
+	state := models.TaskState{Scheduled: scheduled, Pending: pending}
+	if !scheduled {
+		return state
+	}
+
+	cronEntry := s.cron.Entry(entry.id)
+	if !cronEntry.Next.IsZero() {
+		next := cronEntry.Next.UTC()
+		state.Next = &next
+	}
+	if !cronEntry.Prev.IsZero() {
+		prev := cronEntry.Prev.UTC()
+		state.Prev = &prev
+	}
+	state.Skipped = entry.job.Skipped()
+
+	return state
+}
+
+// TriggerTask executes the task right away as a manual execution,
+// apart from its schedule and concurrency policy.
+// It returns the running execution, or the completed one if sync is set,
+// a sync execution is cancelled along with the ctx.
+// It returns nil if the scheduler is stopped.
+func (s *Scheduler) TriggerTask(ctx context.Context, t *models.Task, sync bool) *models.Execution {
+	if !s.runs.begin() {
+		return nil
+	}
+
+	executor := svc.NewExecutor(t, s.executions, s.conf, s.logger)
+	execution := executor.Begin(ctx, time.Now().UTC(), models.TriggerManual)
+	if execution.ID == "" {
+		// the execution couldn't be recorded, it isn't run
+		s.runs.end()
+		return execution
+	}
+	if sync {
+		defer s.runs.end()
+		return executor.Complete(ctx, execution)
+	}
+
+	// the async execution outlives the request, it is drained along with the scheduler
+	running := *execution
+	// its spans are kept in the trace of the request
+	runCtx := trace.ContextWithSpanContext(s.ctx, trace.SpanContextFromContext(ctx))
+	go func() {
+		defer s.runs.end()
+		executor.Complete(runCtx, execution)
+	}()
+	return &running
 }
 
 // DiscardTaskNow removes a task from the scheduler
-// and won't stops the task if it is running.
+// and cancels the in-flight executions of the task.
+// a task pending to be scheduled is dropped as well.
 //
 // if the task is not found in scheduler, it logs a message.
 func (s *Scheduler) DiscardTaskNow(taskID string) {
 	s.tasksMu.Lock()
 	defer s.tasksMu.Unlock()
-	if entryID, exists := s.tasks[taskID]; exists {
-		s.cron.Remove(entryID)
+	s.discardTask(taskID)
+}
+
+// discardTask removes a task from the scheduler.
+// the caller must hold the tasksMu lock.
+func (s *Scheduler) discardTask(taskID string) {
+	s.timers.cancel(taskID)
+	delete(s.pending, taskID)
+	if replay, exists := s.replays[taskID]; exists {
+		replay.cancel()
+		delete(s.replays, taskID)
+	}
+	if entry, exists := s.tasks[taskID]; exists {
+		s.cron.Remove(entry.id)
+		entry.cancel()
 		delete(s.tasks, taskID)
 		s.logger.Info(fmt.Sprintf(deletedTask, taskID))
 		return
@@ -197,16 +835,12 @@ func (s *Scheduler) DiscardTaskNow(taskID string) {
 	s.logger.Info(fmt.Sprintf(noActiveTaskFoundToDiscard, taskID))
 }
 
-// discardAfterEnd discards the task after the duration.
-func (s *Scheduler) discardTaskWithDelay(duration time.Duration, taskID string) {
-	ticker := time.NewTicker(duration)
-
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			s.DiscardTaskNow(taskID)
-			return
-		}
+// expireTask discards the task after its end time,
+// unless the cron entry was replaced by a reschedule in the meantime.
+func (s *Scheduler) expireTask(taskID string, entryID cron.EntryID) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	if entry, exists := s.tasks[taskID]; exists && entry.id == entryID {
+		s.discardTask(taskID)
 	}
 }
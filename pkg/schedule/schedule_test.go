@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/maacarma/scheduler/config"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	utils "github.com/maacarma/scheduler/utils"
+
+	"go.uber.org/zap"
+)
+
+// fakeRepo is an in-memory repo of the active tasks.
+type fakeRepo struct {
+	tasks []*models.Task
+	err   error
+
+	mu       sync.Mutex
+	runCount map[string]int64
+}
+
+func (r *fakeRepo) GetActiveTasks(ctx context.Context, curUnix utils.Unix, shard models.Shard) ([]*models.Task, error) {
+	return r.tasks, r.err
+}
+
+func (r *fakeRepo) Complete(ctx context.Context, id string) error { return nil }
+
+func (r *fakeRepo) IncrementRunCount(ctx context.Context, id string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runCount == nil {
+		r.runCount = map[string]int64{}
+	}
+	r.runCount[id]++
+	return r.runCount[id], nil
+}
+
+func (r *fakeRepo) UpdateLastFire(ctx context.Context, id string, fireUnix int64) error { return nil }
+
+// fakeExecutions is an in-memory execution history.
+type fakeExecutions struct {
+	mu         sync.Mutex
+	executions []models.Execution
+}
+
+func (e *fakeExecutions) CreateExecution(ctx context.Context, execution *models.Execution) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	recorded := *execution
+	recorded.ID = strconv.Itoa(len(e.executions) + 1)
+	e.executions = append(e.executions, recorded)
+	return recorded.ID, nil
+}
+
+func (e *fakeExecutions) UpdateExecution(ctx context.Context, execution *models.Execution) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.executions {
+		if e.executions[i].ID == execution.ID {
+			e.executions[i] = *execution
+		}
+	}
+	return nil
+}
+
+func (e *fakeExecutions) GetExecutions(ctx context.Context, taskID string, filter models.ExecutionFilter) ([]*models.Execution, error) {
+	return nil, nil
+}
+
+// statuses returns the statuses of the recorded executions, in the order they were recorded.
+func (e *fakeExecutions) statuses() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	statuses := make([]string, 0, len(e.executions))
+	for _, execution := range e.executions {
+		statuses = append(statuses, execution.Status)
+	}
+	return statuses
+}
+
+func testConfig() *config.Config {
+	conf := &config.Config{}
+	conf.Scheduler.Timeout = 5 * time.Second
+	conf.Scheduler.DrainTimeout = 5 * time.Second
+	return conf
+}
+
+func TestStartSkipsUnreadableTasks(t *testing.T) {
+	start := time.Now().Add(time.Hour).Unix()
+	readable := &models.Task{ID: "1", Interval: "1h", StartUnix: start, EndUnix: start + 3600}
+	repo := &fakeRepo{
+		tasks: []*models.Task{readable},
+		err:   &models.SkippedTasksError{Errs: []error{errors.New("failed to decrypt the secrets of task 2")}},
+	}
+
+	s := newScheduler(context.Background(), repo, &fakeExecutions{}, testConfig(), zap.NewNop())
+	defer s.Stop(context.Background())
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("got %v, want the unreadable task skipped", err)
+	}
+	if !s.isScheduled("1") {
+		t.Fatal("the readable task isn't scheduled")
+	}
+
+	repo.err = errors.New("connection refused")
+	if err := s.Resync(context.Background()); err == nil {
+		t.Fatal("want the error of the repo")
+	}
+}
@@ -0,0 +1,151 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// tokenTimeout is the timeout of fetching an access token from a token url.
+	tokenTimeout = 10 * time.Second
+	// tokenEarlyExpiry refreshes the access tokens this long before they expire,
+	// so that a token doesn't expire in the middle of a request.
+	tokenEarlyExpiry = 30 * time.Second
+	tokenFetchFailed = "failed to fetch the access token: %w"
+	// maxTokenSources bounds the cached token sources, the least recently used is evicted beyond it.
+	maxTokenSources = 1024
+	// tokenSourceIdle evicts the token sources unused for this long (Ex: of the deleted tasks).
+	tokenSourceIdle = time.Hour
+)
+
+// tokenSources caches the token sources of the oauth2 client credentials by the credentials,
+// so that the access tokens are shared by the runs of the tasks until they are about to expire.
+var tokenSources = newTokenCache(maxTokenSources, tokenSourceIdle)
+
+// tokenCache is a cache of token sources bounded in size, whose idle entries expire.
+type tokenCache struct {
+	mu      sync.Mutex
+	sources map[string]*cachedSource
+	max     int
+	idle    time.Duration
+	now     func() time.Time
+}
+
+// cachedSource is a cached token source and the last time it was used.
+type cachedSource struct {
+	source oauth2.TokenSource
+	usedAt time.Time
+}
+
+func newTokenCache(maxSources int, idle time.Duration) *tokenCache {
+	return &tokenCache{sources: map[string]*cachedSource{}, max: maxSources, idle: idle, now: time.Now}
+}
+
+// get returns the token source cached by the key, the one returned by create is cached if none.
+func (c *tokenCache) get(key string, create func() oauth2.TokenSource) oauth2.TokenSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if cached, ok := c.sources[key]; ok && now.Sub(cached.usedAt) < c.idle {
+		cached.usedAt = now
+		return cached.source
+	}
+
+	c.evict(now)
+	cached := &cachedSource{source: create(), usedAt: now}
+	c.sources[key] = cached
+	return cached.source
+}
+
+// delete drops the token source cached by the key.
+func (c *tokenCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sources, key)
+}
+
+// evict drops the idle token sources, and the least recently used one if the cache is still full.
+// the caller must hold the mu lock.
+func (c *tokenCache) evict(now time.Time) {
+	var oldest string
+	for key, cached := range c.sources {
+		if now.Sub(cached.usedAt) >= c.idle {
+			delete(c.sources, key)
+			continue
+		}
+		if oldest == "" || cached.usedAt.Before(c.sources[oldest].usedAt) {
+			oldest = key
+		}
+	}
+
+	if len(c.sources) >= c.max {
+		delete(c.sources, oldest)
+	}
+}
+
+// authenticate applies the auth of the task to the request,
+// it overrides the Authorization header of the task if any.
+func authenticate(req *http.Request, auth *models.Auth) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case models.AuthBasic:
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case models.AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	case models.AuthOAuth2:
+		token, err := tokenSource(auth).Token()
+		if err != nil {
+			return fmt.Errorf(tokenFetchFailed, err)
+		}
+		token.SetAuthHeader(req)
+	}
+
+	return nil
+}
+
+// invalidateToken drops the cached access token of the auth,
+// so that the next request fetches a new one (Ex: the token was revoked before it expired).
+func invalidateToken(auth *models.Auth) {
+	if auth != nil && auth.Type == models.AuthOAuth2 {
+		tokenSources.delete(credentialsKey(auth))
+	}
+}
+
+// tokenSource returns the cached token source of the oauth2 client credentials of the auth.
+func tokenSource(auth *models.Auth) oauth2.TokenSource {
+	return tokenSources.get(credentialsKey(auth), func() oauth2.TokenSource {
+		conf := &clientcredentials.Config{
+			ClientID:     auth.ClientID,
+			ClientSecret: auth.ClientSecret,
+			TokenURL:     auth.TokenURL,
+			Scopes:       auth.Scopes,
+		}
+		// the token source outlives the runs, the tokens are fetched within the token timeout instead
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: tokenTimeout})
+		return oauth2.ReuseTokenSourceWithExpiry(nil, conf.TokenSource(ctx), tokenEarlyExpiry)
+	})
+}
+
+// credentialsKey returns the cache key of the oauth2 client credentials of the auth,
+// the secret is hashed so that it isn't kept in the key.
+func credentialsKey(auth *models.Auth) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		auth.TokenURL, auth.ClientID, auth.ClientSecret, strings.Join(auth.Scopes, " "),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
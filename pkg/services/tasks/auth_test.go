@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newTokenCache(2, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	created := 0
+	get := func(key string) oauth2.TokenSource {
+		return cache.get(key, func() oauth2.TokenSource {
+			created++
+			return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: key})
+		})
+	}
+	assertCreated := func(want int) {
+		t.Helper()
+		if created != want {
+			t.Fatalf("got %d token sources created, want %d", created, want)
+		}
+	}
+
+	get("a")
+	get("a")
+	assertCreated(1)
+
+	// the least recently used source is evicted once the cache is full
+	now = now.Add(time.Minute)
+	get("b")
+	now = now.Add(time.Minute)
+	get("a")
+	get("c")
+	assertCreated(3)
+	if _, ok := cache.sources["b"]; ok || len(cache.sources) != 2 {
+		t.Fatalf("got sources %v, want a and c", cache.sources)
+	}
+
+	// the idle sources expire
+	now = now.Add(time.Hour)
+	get("a")
+	assertCreated(4)
+	if len(cache.sources) != 1 {
+		t.Fatalf("got %d sources, want the idle ones evicted", len(cache.sources))
+	}
+
+	cache.delete("a")
+	get("a")
+	assertCreated(5)
+}
@@ -0,0 +1,438 @@
+package tasks
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	config "github.com/maacarma/scheduler/config"
+	metrics "github.com/maacarma/scheduler/pkg/metrics"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	signing "github.com/maacarma/scheduler/pkg/signing"
+	tracing "github.com/maacarma/scheduler/pkg/tracing"
+	utils "github.com/maacarma/scheduler/utils"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	unexpectedStatus = "unexpected status code %d"
+	retryAfterTooBig = "retry-after of %s exceeds the max delay %s"
+	// maxResponseBody is the max size of the response body kept in the execution history.
+	maxResponseBody = 4 << 10
+	// recordTimeout is the timeout of recording an execution in the execution history.
+	recordTimeout = 5 * time.Second
+)
+
+type Executor struct {
+	task       *models.Task
+	executions ExecutionRepo
+	conf       *config.Config
+	logger     *zap.Logger
+}
+
+func NewExecutor(task *models.Task, executions ExecutionRepo, conf *config.Config, logger *zap.Logger) *Executor {
+	return &Executor{task: task, executions: executions, conf: conf, logger: logger}
+}
+
+// Namespace returns the namespace of the task.
+func (s *Executor) Namespace() string {
+	return s.task.Namespace
+}
+
+// Run executes the task scheduled at the given time
+// and records the execution in the execution history.
+// Cancelling the context stops the in-flight request and the pending retries.
+func (s *Executor) Run(ctx context.Context, scheduledAt time.Time) *models.Execution {
+	ctx, span := tracing.Tracer().Start(ctx, "Executor.Run", trace.WithAttributes(
+		attribute.String("task.id", s.task.ID),
+		attribute.String("task.namespace", s.task.Namespace),
+		attribute.String("execution.scheduled_at", scheduledAt.UTC().Format(time.RFC3339)),
+	))
+	defer span.End()
+
+	execution := s.Complete(ctx, s.Begin(ctx, scheduledAt, models.TriggerScheduled))
+	span.SetAttributes(
+		attribute.String("execution.id", execution.ID),
+		attribute.String("execution.status", execution.Status),
+		attribute.Int("execution.attempts", execution.Attempt),
+	)
+	if execution.Status != models.ExecutionSucceeded {
+		span.SetStatus(codes.Error, execution.Error)
+	}
+
+	return execution
+}
+
+// Begin records a running execution of the task scheduled at the given time
+// in the execution history, to be completed by Complete.
+func (s *Executor) Begin(ctx context.Context, scheduledAt time.Time, trigger string) *models.Execution {
+	s.logger.Info("executing: ", zap.String("task_id", s.task.ID), zap.String("trigger", trigger))
+
+	now := time.Now().UTC()
+	execution := &models.Execution{
+		TaskID:      s.task.ID,
+		Status:      models.ExecutionRunning,
+		Trigger:     trigger,
+		ScheduledAt: scheduledAt.UTC(),
+		StartedAt:   now,
+		EndedAt:     now,
+	}
+
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+	id, err := s.executions.CreateExecution(recordCtx, execution)
+	if err != nil {
+		s.logger.Error("failed to record execution", zap.String("task_id", s.task.ID), zap.Error(err))
+	}
+	execution.ID = id
+
+	return execution
+}
+
+// Complete executes the task for the execution started by Begin
+// and records its outcome in the execution history.
+func (s *Executor) Complete(ctx context.Context, execution *models.Execution) *models.Execution {
+	s.Execute(ctx, execution)
+
+	// the execution is recorded even if it was cancelled
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+	if execution.ID == "" {
+		id, err := s.executions.CreateExecution(recordCtx, execution)
+		if err != nil {
+			s.logger.Error("failed to record execution", zap.String("task_id", s.task.ID), zap.Error(err))
+		}
+		execution.ID = id
+	} else if err := s.executions.UpdateExecution(recordCtx, execution); err != nil {
+		s.logger.Error("failed to record execution", zap.String("task_id", s.task.ID), zap.Error(err))
+	}
+	id := execution.ID
+	metrics.ObserveExecution(s.task.Namespace, execution)
+
+	if execution.Status == models.ExecutionCancelled {
+		s.logger.Warn("task execution cancelled", zap.String("task_id", s.task.ID), zap.String("execution_id", id), zap.Int("attempts", execution.Attempt))
+		return execution
+	}
+
+	if execution.Status == models.ExecutionFailed {
+		s.logger.Error("task execution failed", zap.String("task_id", s.task.ID), zap.String("execution_id", id), zap.Int("attempts", execution.Attempt), zap.String("error", execution.Error))
+		return execution
+	}
+
+	s.logger.Info("task executed", zap.String("task_id", s.task.ID), zap.String("execution_id", id), zap.Int("status_code", execution.StatusCode), zap.Int("attempts", execution.Attempt))
+	return execution
+}
+
+// Skip records a run scheduled at the given time as skipped with the reason,
+// without executing the task.
+func (s *Executor) Skip(ctx context.Context, scheduledAt time.Time, reason string) *models.Execution {
+	execution := s.record(ctx, scheduledAt, models.ExecutionSkipped, reason)
+	s.logger.Warn("task execution skipped", zap.String("task_id", s.task.ID), zap.String("reason", reason))
+	return execution
+}
+
+// Miss records a run scheduled at the given time as missed, without executing the task.
+func (s *Executor) Miss(ctx context.Context, scheduledAt time.Time) *models.Execution {
+	execution := s.record(ctx, scheduledAt, models.ExecutionMissed, "")
+	metrics.ObserveMissedFires(s.task.Namespace, 1)
+	s.logger.Warn("task execution missed", zap.String("task_id", s.task.ID), zap.Time("scheduled_at", scheduledAt))
+	return execution
+}
+
+// record records a run scheduled at the given time which wasn't executed.
+func (s *Executor) record(ctx context.Context, scheduledAt time.Time, status, reason string) *models.Execution {
+	now := time.Now().UTC()
+	execution := &models.Execution{
+		TaskID:      s.task.ID,
+		Status:      status,
+		Trigger:     models.TriggerScheduled,
+		ScheduledAt: scheduledAt.UTC(),
+		StartedAt:   now,
+		EndedAt:     now,
+		Error:       reason,
+	}
+
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+	id, err := s.executions.CreateExecution(recordCtx, execution)
+	if err != nil {
+		s.logger.Error("failed to record execution", zap.String("task_id", s.task.ID), zap.Error(err))
+	}
+	execution.ID = id
+	metrics.ObserveExecution(s.task.Namespace, execution)
+
+	return execution
+}
+
+// Execute executes the task and fills the outcome in the execution record.
+// Failed attempts are retried as per the task's retry policy,
+// the http request of each attempt is limited by the task's timeout.
+func (s *Executor) Execute(ctx context.Context, execution *models.Execution) {
+	policy := s.task.Retry.WithDefaults()
+	execution.Status = models.ExecutionFailed
+	execution.Attempts = nil
+	vars := models.TemplateVars{
+		TaskID:      s.task.ID,
+		RunID:       execution.ID,
+		ScheduledAt: models.Time{Time: execution.ScheduledAt},
+	}
+
+	for number := 1; ; number++ {
+		vars.Attempt = number
+		attempt, body, retryable, retryAfter := s.attempt(ctx, vars, &policy)
+		execution.Attempts = append(execution.Attempts, attempt)
+		execution.ResponseBody = body
+		if attempt.Error == "" {
+			execution.Status = models.ExecutionSucceeded
+			break
+		}
+
+		if ctx.Err() != nil {
+			execution.Status = models.ExecutionCancelled
+			break
+		}
+
+		s.logger.Warn("task attempt failed", zap.String("task_id", s.task.ID), zap.Int("attempt", number), zap.String("error", attempt.Error))
+		if !retryable || number >= policy.Attempts() {
+			break
+		}
+
+		delay := policy.Backoff(number)
+		if retryAfter > 0 && !policy.IgnoreRetryAfter {
+			if retryAfter > policy.MaxBackoff() {
+				s.logger.Warn(fmt.Sprintf(retryAfterTooBig, retryAfter, policy.MaxBackoff()), zap.String("task_id", s.task.ID))
+				break
+			}
+			delay = max(delay, retryAfter)
+		}
+
+		if !sleep(ctx, delay) {
+			execution.Status = models.ExecutionCancelled
+			break
+		}
+	}
+
+	last := execution.LastAttempt()
+	execution.EndedAt = time.Now().UTC()
+	execution.Attempt = last.Number
+	execution.StatusCode = last.StatusCode
+	execution.LatencyMs = last.EndedAt.Sub(last.StartedAt).Milliseconds()
+	execution.Error = last.Error
+}
+
+// attempt makes a single http call for the task, with its templates rendered with the variables of the attempt.
+// It returns the attempt record, the truncated response body, whether the failure
+// can be retried and the delay requested by the Retry-After header if any.
+func (s *Executor) attempt(ctx context.Context, vars models.TemplateVars, policy *models.RetryPolicy) (models.Attempt, string, bool, time.Duration) {
+	number := vars.Attempt
+	attempt := models.Attempt{Number: number, StartedAt: time.Now().UTC()}
+
+	ctx, span := tracing.Tracer().Start(ctx, s.task.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("task.id", s.task.ID),
+		semconv.HTTPRequestMethodKey.String(s.task.Method),
+		semconv.HTTPRequestResendCount(number-1),
+	))
+	defer func() {
+		if attempt.StatusCode != 0 {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(attempt.StatusCode))
+		}
+		if attempt.Error != "" {
+			span.SetStatus(codes.Error, attempt.Error)
+		}
+		span.End()
+	}()
+
+	task, err := s.task.Render(vars, templateLookups(s.conf))
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.EndedAt = time.Now().UTC()
+		return attempt, "", false, 0
+	}
+
+	req, err := s.request(ctx, task)
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.EndedAt = time.Now().UTC()
+		return attempt, "", false, 0
+	}
+
+	if err := authenticate(req, s.task.Auth); err != nil {
+		attempt.EndedAt = time.Now().UTC()
+		attempt.Error = err.Error()
+		return attempt, "", policy.RetryOnError(errorKind(err)), 0
+	}
+
+	client, err := httpClient(s.conf, s.task.TLSProfile)
+	if err != nil {
+		attempt.EndedAt = time.Now().UTC()
+		attempt.Error = err.Error()
+		return attempt, "", false, 0
+	}
+
+	// the timeout limits the http call only, reading the response included
+	if timeout := s.task.RequestTimeout(s.conf.Scheduler.Timeout); timeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(timeoutCtx)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		attempt.EndedAt = time.Now().UTC()
+		attempt.Error = err.Error()
+		return attempt, "", policy.RetryOnError(errorKind(err)), 0
+	}
+	defer resp.Body.Close()
+
+	body := readBody(resp.Body)
+	attempt.EndedAt = time.Now().UTC()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return attempt, body, false, 0
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		invalidateToken(s.task.Auth)
+	}
+
+	attempt.Error = fmt.Sprintf(unexpectedStatus, resp.StatusCode)
+	return attempt, body, policy.RetryOnStatus(resp.StatusCode), retryAfter(resp)
+}
+
+// readBody reads the response body truncated to maxResponseBody and drains the rest.
+// invalid utf-8 and null characters are dropped, so that the body can be stored as text.
+func readBody(r io.Reader) string {
+	body, _ := io.ReadAll(io.LimitReader(r, maxResponseBody))
+	io.Copy(io.Discard, r)
+
+	return strings.ReplaceAll(strings.ToValidUTF8(string(body), ""), "\x00", "")
+}
+
+// request builds the http request of the task rendered by Task.Render.
+func (s *Executor) request(ctx context.Context, task *models.Task) (*http.Request, error) {
+	url, err := url.Parse(task.Url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+	utils.AppendQueryParams(url, task.Params)
+
+	bodyBytes, err := json.Marshal(task.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, task.Method, url.String(), bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = task.Headers.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if signer := s.signer(); signer != nil {
+		signer.Sign(req, bodyBytes, time.Now())
+	}
+	// propagates the trace context, so that the task endpoint can correlate the call
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	// the query is left out of the span, as it may carry the secrets of the task
+	spanURL := *req.URL
+	spanURL.RawQuery = ""
+	trace.SpanFromContext(ctx).SetAttributes(semconv.URLFull(spanURL.Redacted()))
+
+	return req, nil
+}
+
+// signer returns the signer of the task's requests, with the keys of the task or else of its namespace.
+// It returns nil if the task has no keys to sign with.
+func (s *Executor) signer() *signing.Signer {
+	conf := s.task.Signing
+	if conf == nil {
+		conf = &models.Signing{}
+	}
+
+	signer := &signing.Signer{
+		SignatureHeader: cmp.Or(conf.SignatureHeader, s.conf.Signing.SignatureHeader),
+		TimestampHeader: cmp.Or(conf.TimestampHeader, s.conf.Signing.TimestampHeader),
+	}
+	for _, key := range conf.Keys {
+		signer.Keys = append(signer.Keys, signing.Key{ID: key.ID, Secret: key.Secret})
+	}
+	if len(signer.Keys) == 0 {
+		// the namespaces are lower cased by the config loader
+		signer.Keys = s.conf.Signing.Namespaces[strings.ToLower(s.task.Namespace)]
+	}
+	if len(signer.Keys) == 0 {
+		return nil
+	}
+
+	return signer
+}
+
+// sleep waits for the duration, it returns false if the context is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// errorKind classifies the transport error into one of the retryable error kinds.
+func errorKind(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return models.ErrKindDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return models.ErrKindTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return models.ErrKindConnection
+	default:
+		return models.ErrKindOther
+	}
+}
+
+// retryAfter parses the Retry-After header of the response,
+// which is either delay in seconds or a http date.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,140 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/maacarma/scheduler/config"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	tracing "github.com/maacarma/scheduler/pkg/tracing"
+	utils "github.com/maacarma/scheduler/utils"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// executionRecorder is an in-memory execution history.
+type executionRecorder struct {
+	mu         sync.Mutex
+	executions []*models.Execution
+}
+
+func (r *executionRecorder) CreateExecution(ctx context.Context, execution *models.Execution) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.executions = append(r.executions, execution)
+	return "run-1", nil
+}
+
+func (r *executionRecorder) UpdateExecution(ctx context.Context, execution *models.Execution) error {
+	return nil
+}
+
+func (r *executionRecorder) GetExecutions(ctx context.Context, taskID string, filter models.ExecutionFilter) ([]*models.Execution, error) {
+	return nil, nil
+}
+
+func TestRunSpans(t *testing.T) {
+	conf := &config.Config{}
+	conf.Application.Name = "scheduler"
+	conf.Scheduler.Timeout = 5 * time.Second
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(conf, exporter)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		provider.Shutdown(context.Background())
+	})
+
+	// the first attempt fails with a retryable status, the retry succeeds
+	var mu sync.Mutex
+	var traceparents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		if len(traceparents) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := &models.Task{
+		ID:        "1",
+		Namespace: "billing",
+		Url:       server.URL,
+		Method:    http.MethodGet,
+		Retry:     &models.RetryPolicy{MaxAttempts: utils.Ptr(2), InitialDelay: "1ms", Jitter: utils.Ptr(0.0)},
+	}
+	executor := NewExecutor(task, &executionRecorder{}, conf, zap.NewNop())
+	execution := executor.Run(context.Background(), time.Now())
+	if execution.Status != models.ExecutionSucceeded {
+		t.Fatalf("execution %s: %s", execution.Status, execution.Error)
+	}
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	// the attempts end before the run, so the run is exported last
+	run, attempts := spans[2], spans[:2]
+	if run.Name != "Executor.Run" || run.Parent.IsValid() {
+		t.Fatalf("root span: got %q with parent %v", run.Name, run.Parent.SpanID())
+	}
+	assertAttribute(t, run, attribute.String("task.id", "1"))
+	assertAttribute(t, run, attribute.String("execution.status", models.ExecutionSucceeded))
+	assertAttribute(t, run, attribute.Int("execution.attempts", 2))
+
+	for i, attempt := range attempts {
+		if attempt.Name != http.MethodGet || attempt.SpanKind != trace.SpanKindClient {
+			t.Fatalf("attempt %d: got %q of kind %s", i, attempt.Name, attempt.SpanKind)
+		}
+		if attempt.Parent.SpanID() != run.SpanContext.SpanID() {
+			t.Fatalf("attempt %d: not a child of the run span", i)
+		}
+		assertAttribute(t, attempt, attribute.Int("http.request.resend_count", i))
+
+		// the trace context is propagated to the task's endpoint
+		want := "00-" + attempt.SpanContext.TraceID().String() + "-" + attempt.SpanContext.SpanID().String() + "-01"
+		if traceparents[i] != want {
+			t.Fatalf("attempt %d: got traceparent %q, want %q", i, traceparents[i], want)
+		}
+	}
+	assertAttribute(t, attempts[0], attribute.Int("http.response.status_code", http.StatusServiceUnavailable))
+	if attempts[0].Status.Code != codes.Error {
+		t.Fatalf("failed attempt: got status %s, want error", attempts[0].Status.Code)
+	}
+	assertAttribute(t, attempts[1], attribute.Int("http.response.status_code", http.StatusOK))
+}
+
+// assertAttribute checks the span has the attribute.
+func assertAttribute(t *testing.T, span tracetest.SpanStub, want attribute.KeyValue) {
+	t.Helper()
+	for _, attr := range span.Attributes {
+		if attr.Key == want.Key {
+			if attr.Value != want.Value {
+				t.Fatalf("span %q: got %s=%s, want %s", span.Name, attr.Key, attr.Value.Emit(), want.Value.Emit())
+			}
+			return
+		}
+	}
+	t.Fatalf("span %q: missing attribute %s", span.Name, want.Key)
+}
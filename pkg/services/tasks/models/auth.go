@@ -0,0 +1,87 @@
+package task
+
+import (
+	"net/url"
+
+	errors "github.com/maacarma/scheduler/pkg/errors"
+	utils "github.com/maacarma/scheduler/utils"
+)
+
+// types of the authentication of a task's requests
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+)
+
+var authTypes = []string{AuthBasic, AuthBearer, AuthOAuth2}
+
+// Auth configures the authentication of a task's requests.
+//
+// basic authenticates with the Username and Password, bearer with the static Token
+// and oauth2 with an access token of the OAuth2 client credentials grant,
+// fetched from the TokenURL with the ClientID, ClientSecret and Scopes.
+// The access tokens are cached and refreshed before they expire.
+//
+// The secrets (Password, Token, ClientSecret) are redacted in the api responses.
+type Auth struct {
+	Type         string   `json:"type" bson:"type"`
+	Username     string   `json:"username,omitempty" bson:"username,omitempty"`
+	Password     string   `json:"password,omitempty" bson:"password,omitempty"`
+	Token        string   `json:"token,omitempty" bson:"token,omitempty"`
+	TokenURL     string   `json:"token_url,omitempty" bson:"token_url,omitempty"`
+	ClientID     string   `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty" bson:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty" bson:"scopes,omitempty"`
+}
+
+// Validate validates the auth.
+func (a *Auth) Validate() *errors.Validation {
+	if !utils.Contains(authTypes, a.Type) {
+		return errors.InvalidPayload("auth.type", errors.InvalidFieldMsg)
+	}
+
+	switch a.Type {
+	case AuthBasic:
+		if a.Username == "" {
+			return errors.InvalidPayload("auth.username", errors.RequiredFieldMsg)
+		}
+	case AuthBearer:
+		if a.Token == "" {
+			return errors.InvalidPayload("auth.token", errors.RequiredFieldMsg)
+		}
+	case AuthOAuth2:
+		if a.TokenURL == "" {
+			return errors.InvalidPayload("auth.token_url", errors.RequiredFieldMsg)
+		}
+		if u, err := url.Parse(a.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.InvalidPayload("auth.token_url", errors.InvalidFieldMsg, "token_url should be an absolute url")
+		}
+		if a.ClientID == "" {
+			return errors.InvalidPayload("auth.client_id", errors.RequiredFieldMsg)
+		}
+		if a.ClientSecret == "" {
+			return errors.InvalidPayload("auth.client_secret", errors.RequiredFieldMsg)
+		}
+	}
+
+	return nil
+}
+
+// Restore restores the secrets left redacted from the previous auth,
+// so that a task read from the api can be sent back as is.
+func (a *Auth) Restore(prev *Auth) {
+	if a == nil || prev == nil {
+		return
+	}
+
+	if a.Password == Redacted {
+		a.Password = prev.Password
+	}
+	if a.Token == Redacted {
+		a.Token = prev.Token
+	}
+	if a.ClientSecret == Redacted {
+		a.ClientSecret = prev.ClientSecret
+	}
+}
@@ -0,0 +1,100 @@
+package task
+
+import "time"
+
+// statuses of an execution
+const (
+	// ExecutionRunning is the status of an execution in-flight.
+	ExecutionRunning   = "running"
+	ExecutionSucceeded = "succeeded"
+	ExecutionFailed    = "failed"
+	// ExecutionCancelled is the status of an execution interrupted by
+	// pausing or deleting the task or shutting down the scheduler.
+	ExecutionCancelled = "cancelled"
+	// ExecutionSkipped is the status of a run skipped by the task's concurrency policy.
+	ExecutionSkipped = "skipped"
+	// ExecutionMissed is the status of a run missed while the scheduler was down,
+	// recorded as per the task's misfire policy.
+	ExecutionMissed = "missed"
+)
+
+// triggers of an execution
+const (
+	// TriggerScheduled is the trigger of the executions fired by the schedule.
+	TriggerScheduled = "scheduled"
+	// TriggerManual is the trigger of the executions run on demand through the api.
+	TriggerManual = "manual"
+)
+
+// defaults and bounds of the executions pagination
+const (
+	DefaultExecutionsLimit = 50
+	MaxExecutionsLimit     = 500
+)
+
+// Attempt represents a single http call made while executing a task.
+type Attempt struct {
+	Number     int       `json:"number" bson:"number"`
+	StartedAt  time.Time `json:"started_at" bson:"started_at"`
+	EndedAt    time.Time `json:"ended_at" bson:"ended_at"`
+	StatusCode int       `json:"status_code" bson:"status_code"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Execution represents a single run of a task.
+// A run takes multiple attempts when the task has a retry policy,
+// Attempt, StatusCode, LatencyMs, ResponseBody and Error are of the last attempt.
+// ResponseBody is truncated to a few kilobytes.
+type Execution struct {
+	ID           string    `json:"_id" bson:"_id,omitempty"`
+	TaskID       string    `json:"task_id" bson:"task_id"`
+	Status       string    `json:"status" bson:"status"`
+	Trigger      string    `json:"trigger" bson:"trigger"`
+	ScheduledAt  time.Time `json:"scheduled_at" bson:"scheduled_at"`
+	StartedAt    time.Time `json:"started_at" bson:"started_at"`
+	EndedAt      time.Time `json:"ended_at" bson:"ended_at"`
+	Attempt      int       `json:"attempt" bson:"attempt"`
+	StatusCode   int       `json:"status_code" bson:"status_code"`
+	LatencyMs    int64     `json:"latency_ms" bson:"latency_ms"`
+	ResponseBody string    `json:"response_body" bson:"response_body"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	Attempts     []Attempt `json:"attempts" bson:"attempts"`
+}
+
+// ExecutionFilter filters the executions of a task by the scheduled time,
+// Since is inclusive and Until is exclusive.
+type ExecutionFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// RunOverride overrides the body and params of a task for a single manual run.
+// Unset fields keep the task's values.
+type RunOverride struct {
+	Body   MapAny              `json:"body"`
+	Params map[string][]string `json:"params"`
+}
+
+// Apply returns a copy of the task with the overrides applied.
+func (o *RunOverride) Apply(t *Task) *Task {
+	task := *t
+	if o.Body != nil {
+		task.Body = o.Body
+	}
+	if o.Params != nil {
+		task.Params = o.Params
+	}
+
+	return &task
+}
+
+// LastAttempt returns the last attempt of the execution.
+func (e *Execution) LastAttempt() *Attempt {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+
+	return &e.Attempts[len(e.Attempts)-1]
+}
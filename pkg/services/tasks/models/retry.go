@@ -0,0 +1,197 @@
+package task
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	errors "github.com/maacarma/scheduler/pkg/errors"
+	utils "github.com/maacarma/scheduler/utils"
+)
+
+// kinds of transport errors that can be retried
+const (
+	ErrKindTimeout    = "timeout"
+	ErrKindConnection = "connection"
+	ErrKindDNS        = "dns"
+	ErrKindOther      = "other"
+)
+
+var errKinds = []string{ErrKindTimeout, ErrKindConnection, ErrKindDNS, ErrKindOther}
+
+// defaults of the retry policy
+var (
+	defaultMaxAttempts       = 3
+	maxMaxAttempts           = 10
+	defaultInitialDelay      = time.Second
+	defaultMultiplier        = 2.0
+	defaultMaxDelay          = time.Minute
+	defaultJitter            = 0.2
+	defaultRetryableStatuses = []int{
+		http.StatusRequestTimeout,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+	defaultRetryableErrors = []string{ErrKindTimeout, ErrKindConnection, ErrKindDNS}
+)
+
+// RetryPolicy configures how a failed execution of a task is retried.
+// A task without a retry policy is attempted only once.
+//
+// InitialDelay and MaxDelay are strings accepted by time.ParseDuration.
+// The delay before the nth retry is InitialDelay * Multiplier^(n-1) capped to MaxDelay,
+// reduced by a random fraction of itself up to Jitter (0 to 1).
+//
+// Responses with a status in RetryableStatuses and transport errors of a kind in
+// RetryableErrors (timeout, connection, dns, other) are retried.
+// Retry-After headers are honoured unless IgnoreRetryAfter is set,
+// a Retry-After longer than MaxDelay stops the retries.
+// MaxAttempts is between 1 and 10, counting the first attempt.
+// Unset fields takes the defaults defined above, MaxAttempts and Jitter are pointers
+// so that a jitter of 0 can be told apart from an unset one.
+type RetryPolicy struct {
+	MaxAttempts       *int     `json:"max_attempts" bson:"max_attempts"`
+	InitialDelay      string   `json:"initial_delay" bson:"initial_delay"`
+	Multiplier        float64  `json:"multiplier" bson:"multiplier"`
+	MaxDelay          string   `json:"max_delay" bson:"max_delay"`
+	Jitter            *float64 `json:"jitter" bson:"jitter"`
+	RetryableStatuses []int    `json:"retryable_statuses" bson:"retryable_statuses"`
+	RetryableErrors   []string `json:"retryable_errors" bson:"retryable_errors"`
+	IgnoreRetryAfter  bool     `json:"ignore_retry_after" bson:"ignore_retry_after"`
+}
+
+// Validate validates the retry policy.
+func (p *RetryPolicy) Validate() *errors.Validation {
+	if p.MaxAttempts != nil && (*p.MaxAttempts < 1 || *p.MaxAttempts > maxMaxAttempts) {
+		return errors.InvalidPayload("retry.max_attempts", errors.InvalidFieldMsg, fmt.Sprintf("max_attempts should be between 1 and %d", maxMaxAttempts))
+	}
+
+	var initialDelay, maxDelay time.Duration
+	var err error
+	if p.InitialDelay != "" {
+		if initialDelay, err = parseDelay(p.InitialDelay); err != nil {
+			return errors.InvalidPayload("retry.initial_delay", errors.InvalidFieldMsg, err.Error())
+		}
+	}
+
+	if p.MaxDelay != "" {
+		if maxDelay, err = parseDelay(p.MaxDelay); err != nil {
+			return errors.InvalidPayload("retry.max_delay", errors.InvalidFieldMsg, err.Error())
+		}
+	}
+
+	if p.InitialDelay != "" && p.MaxDelay != "" && initialDelay > maxDelay {
+		return errors.InvalidPayload("retry.max_delay", errors.InvalidFieldMsg, "max_delay should be greater than initial_delay")
+	}
+
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return errors.InvalidPayload("retry.multiplier", errors.InvalidFieldMsg, "multiplier should be at least 1")
+	}
+
+	if p.Jitter != nil && (*p.Jitter < 0 || *p.Jitter > 1) {
+		return errors.InvalidPayload("retry.jitter", errors.InvalidFieldMsg, "jitter should be between 0 and 1")
+	}
+
+	for _, status := range p.RetryableStatuses {
+		if status < 100 || status > 599 {
+			return errors.InvalidPayload("retry.retryable_statuses", errors.InvalidFieldMsg, fmt.Sprintf("invalid status code %d", status))
+		}
+	}
+
+	for _, kind := range p.RetryableErrors {
+		if !utils.Contains(errKinds, kind) {
+			return errors.InvalidPayload("retry.retryable_errors", errors.InvalidFieldMsg, fmt.Sprintf("unknown error kind %s", kind))
+		}
+	}
+
+	return nil
+}
+
+// WithDefaults returns a copy of the retry policy with the unset fields set to defaults.
+// A nil policy results in a single attempt.
+func (p *RetryPolicy) WithDefaults() RetryPolicy {
+	if p == nil {
+		return RetryPolicy{MaxAttempts: utils.Ptr(1), Jitter: utils.Ptr(0.0)}
+	}
+
+	policy := *p
+	if policy.MaxAttempts == nil {
+		policy.MaxAttempts = utils.Ptr(defaultMaxAttempts)
+	}
+	if policy.InitialDelay == "" {
+		policy.InitialDelay = defaultInitialDelay.String()
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = defaultMultiplier
+	}
+	if policy.MaxDelay == "" {
+		policy.MaxDelay = defaultMaxDelay.String()
+	}
+	if policy.Jitter == nil {
+		policy.Jitter = utils.Ptr(defaultJitter)
+	}
+	if policy.RetryableStatuses == nil {
+		policy.RetryableStatuses = defaultRetryableStatuses
+	}
+	if policy.RetryableErrors == nil {
+		policy.RetryableErrors = defaultRetryableErrors
+	}
+
+	return policy
+}
+
+// Attempts returns the max attempts of the policy returned by WithDefaults.
+func (p *RetryPolicy) Attempts() int {
+	return *p.MaxAttempts
+}
+
+// Backoff returns the delay before the given retry, starting from 1,
+// of the policy returned by WithDefaults.
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	initialDelay, _ := time.ParseDuration(p.InitialDelay)
+	maxDelay := p.MaxBackoff()
+
+	delay := float64(initialDelay) * math.Pow(p.Multiplier, float64(retry-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	delay -= delay * *p.Jitter * rand.Float64()
+	return time.Duration(delay)
+}
+
+// MaxBackoff returns the maximum delay between the retries.
+func (p *RetryPolicy) MaxBackoff() time.Duration {
+	maxDelay, _ := time.ParseDuration(p.MaxDelay)
+	return maxDelay
+}
+
+// RetryOnStatus checks if a response with the status code should be retried.
+func (p *RetryPolicy) RetryOnStatus(statusCode int) bool {
+	return utils.Contains(p.RetryableStatuses, statusCode)
+}
+
+// RetryOnError checks if a transport error of the kind should be retried.
+func (p *RetryPolicy) RetryOnError(kind string) bool {
+	return utils.Contains(p.RetryableErrors, kind)
+}
+
+// parseDelay parses a non negative delay duration.
+func parseDelay(delay string) (time.Duration, error) {
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("delay should be positive")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,362 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	encryption "github.com/maacarma/scheduler/pkg/encryption"
+	errors "github.com/maacarma/scheduler/pkg/errors"
+)
+
+// Redacted replaces the secrets of a task in the api responses.
+const Redacted = "[REDACTED]"
+
+const (
+	decryptFailed = "failed to decrypt the secrets of task %s: %w"
+	skippedTasks  = "skipped %d tasks: %s"
+)
+
+// prefixes of the secret fields of a task
+const (
+	secretHeaderPrefix = "headers."
+	secretBodyPrefix   = "body."
+)
+
+// sensitiveHeaders are the headers of a task which are always secret.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// Cipher encrypts the secrets of the tasks at rest, see the encryption package.
+type Cipher interface {
+	// Enabled checks if the secrets are encrypted, they are stored in plain otherwise.
+	Enabled() bool
+	Encrypt(plaintext []byte) (string, error)
+	Decrypt(value string) ([]byte, error)
+	// Encrypted checks if the value is encrypted.
+	Encrypted(value string) bool
+	// Current checks if the value is encrypted with the current key.
+	Current(value string) bool
+}
+
+// SkippedTasksError is returned along with the tasks read, when some tasks couldn't be read
+// (Ex: their secrets can't be decrypted), so that a single corrupted task doesn't keep the others from running.
+type SkippedTasksError struct {
+	Errs []error
+}
+
+func (e *SkippedTasksError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf(skippedTasks, len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// secrets are the fields of a task's definition holding its secrets,
+// the secrets of the auth and signing, the sensitive headers and the marked secret fields.
+type secrets struct {
+	headers http.Header
+	body    MapAny
+	auth    *Auth
+	signing *Signing
+	fields  []string
+}
+
+func (t *Task) secrets() secrets {
+	return secrets{headers: t.Headers, body: t.Body, auth: t.Auth, signing: t.Signing, fields: t.SecretFields}
+}
+
+func (t *TaskPayload) secrets() secrets {
+	return secrets{headers: t.Headers, body: t.Body, auth: t.Auth, signing: t.Signing, fields: t.SecretFields}
+}
+
+// clone returns a copy of the secrets which can be modified without affecting the task.
+func (s secrets) clone() secrets {
+	cloned := s
+	cloned.headers = s.headers.Clone()
+	if s.body != nil {
+		// only the top-level values of the body are secret
+		cloned.body = make(MapAny, len(s.body))
+		for key, value := range s.body {
+			cloned.body[key] = value
+		}
+	}
+	if s.auth != nil {
+		auth := *s.auth
+		cloned.auth = &auth
+	}
+	if s.signing != nil {
+		signing := *s.signing
+		signing.Keys = append([]SigningKey(nil), s.signing.Keys...)
+		cloned.signing = &signing
+	}
+	return cloned
+}
+
+// walk replaces each secret string with str and each secret body value with value,
+// the empty strings and null values are skipped.
+func (s secrets) walk(str func(string) (string, error), value func(any) (any, error)) error {
+	replace := func(secret *string) error {
+		if *secret == "" {
+			return nil
+		}
+		var err error
+		*secret, err = str(*secret)
+		return err
+	}
+
+	for name, values := range s.headers {
+		if !s.secretHeader(name) {
+			continue
+		}
+		for i := range values {
+			if err := replace(&values[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range s.bodyKeys() {
+		if v, ok := s.body[key]; ok && v != nil {
+			replaced, err := value(v)
+			if err != nil {
+				return err
+			}
+			s.body[key] = replaced
+		}
+	}
+
+	if s.auth != nil {
+		for _, secret := range []*string{&s.auth.Password, &s.auth.Token, &s.auth.ClientSecret} {
+			if err := replace(secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.signing != nil {
+		for i := range s.signing.Keys {
+			if err := replace(&s.signing.Keys[i].Secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// secretHeader checks if the header is secret. the names are matched in any case,
+// as the headers decoded from JSON keep the names as sent by the client.
+func (s secrets) secretHeader(name string) bool {
+	for _, sensitive := range sensitiveHeaders {
+		if strings.EqualFold(name, sensitive) {
+			return true
+		}
+	}
+	for _, field := range s.fields {
+		if header, ok := strings.CutPrefix(field, secretHeaderPrefix); ok && strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyKeys returns the secret top-level keys of the body.
+func (s secrets) bodyKeys() []string {
+	keys := []string{}
+	for _, field := range s.fields {
+		if key, ok := strings.CutPrefix(field, secretBodyPrefix); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// validateSecretFields validates the secret fields, "headers.<name>" or "body.<top-level key>".
+func validateSecretFields(fields []string) *errors.Validation {
+	for _, field := range fields {
+		header, isHeader := strings.CutPrefix(field, secretHeaderPrefix)
+		key, isBody := strings.CutPrefix(field, secretBodyPrefix)
+		switch {
+		case isHeader && header != "" && validHeader(header):
+		case isBody && key != "" && !strings.Contains(key, "."):
+		default:
+			return errors.InvalidPayload("secret_fields", errors.InvalidFieldMsg, "unknown secret field "+field+", expected headers.<name> or body.<top-level key>")
+		}
+	}
+	return nil
+}
+
+// validateValues rejects the secrets starting with the prefix of the encrypted values,
+// they would be taken for ciphertexts when read back.
+func (s secrets) validateValues() *errors.Validation {
+	encrypted := func(value string) bool { return strings.HasPrefix(value, encryption.Prefix) }
+	invalid := func(field string) *errors.Validation {
+		return errors.InvalidPayload(field, errors.InvalidFieldMsg, "secrets can't start with "+encryption.Prefix)
+	}
+
+	for name, values := range s.headers {
+		if !s.secretHeader(name) {
+			continue
+		}
+		for _, value := range values {
+			if encrypted(value) {
+				return invalid(secretHeaderPrefix + name)
+			}
+		}
+	}
+
+	for _, key := range s.bodyKeys() {
+		// the body values are encrypted in their JSON form, only the strings can look encrypted
+		if value, ok := s.body[key].(string); ok && encrypted(value) {
+			return invalid(secretBodyPrefix + key)
+		}
+	}
+
+	if s.auth != nil {
+		switch {
+		case encrypted(s.auth.Password):
+			return invalid("auth.password")
+		case encrypted(s.auth.Token):
+			return invalid("auth.token")
+		case encrypted(s.auth.ClientSecret):
+			return invalid("auth.client_secret")
+		}
+	}
+
+	if s.signing != nil {
+		for i, key := range s.signing.Keys {
+			if encrypted(key.Secret) {
+				return invalid(fmt.Sprintf("signing.keys[%d].secret", i))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Redact returns a copy of the task with its secrets redacted,
+// the copy is meant for the api responses only.
+func (t *Task) Redact() *Task {
+	redacted := *t
+	s := t.secrets().clone()
+	s.walk(
+		func(string) (string, error) { return Redacted, nil },
+		func(any) (any, error) { return Redacted, nil },
+	)
+	redacted.Headers, redacted.Body, redacted.Auth, redacted.Signing = s.headers, s.body, s.auth, s.signing
+	return &redacted
+}
+
+// RestoreSecrets restores the secrets left redacted from the previous task,
+// so that a task read from the api can be sent back as is.
+func (t *TaskPayload) RestoreSecrets(prev *Task) {
+	t.Auth.Restore(prev.Auth)
+	t.Signing.Restore(prev.Signing)
+
+	s := t.secrets()
+	for name, values := range t.Headers {
+		if s.secretHeader(name) && len(values) > 0 && values[0] == Redacted {
+			t.Headers[name] = headerValues(prev.Headers, name)
+		}
+	}
+	for _, key := range s.bodyKeys() {
+		if value, ok := t.Body[key].(string); ok && value == Redacted {
+			t.Body[key] = prev.Body[key]
+		}
+	}
+}
+
+// headerValues returns the values of the header, its name matched in any case.
+func headerValues(headers http.Header, name string) []string {
+	for key, values := range headers {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// EncryptSecrets returns a copy of the task payload with its secrets encrypted by the cipher,
+// the body values are encrypted in their JSON form. The payload is returned as is if the cipher is disabled.
+func (t *TaskPayload) EncryptSecrets(c Cipher) (*TaskPayload, error) {
+	if !c.Enabled() {
+		return t, nil
+	}
+
+	encrypted := *t
+	s := t.secrets().clone()
+	err := s.walk(
+		func(secret string) (string, error) { return c.Encrypt([]byte(secret)) },
+		func(value any) (any, error) {
+			plaintext, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			return c.Encrypt(plaintext)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted.Headers, encrypted.Body, encrypted.Auth, encrypted.Signing = s.headers, s.body, s.auth, s.signing
+	return &encrypted, nil
+}
+
+// DecryptSecrets decrypts the secrets of the task encrypted by the cipher in place,
+// the secrets stored in plain are left as is.
+func (t *Task) DecryptSecrets(c Cipher) error {
+	err := t.secrets().walk(
+		func(secret string) (string, error) {
+			if !c.Encrypted(secret) {
+				return secret, nil
+			}
+			plaintext, err := c.Decrypt(secret)
+			return string(plaintext), err
+		},
+		func(value any) (any, error) {
+			secret, ok := value.(string)
+			if !ok || !c.Encrypted(secret) {
+				return value, nil
+			}
+			plaintext, err := c.Decrypt(secret)
+			if err != nil {
+				return nil, err
+			}
+			var decrypted any
+			err = json.Unmarshal(plaintext, &decrypted)
+			return decrypted, err
+		},
+	)
+	if err != nil {
+		return fmt.Errorf(decryptFailed, t.ID, err)
+	}
+
+	return nil
+}
+
+// SecretsCurrent checks if the stored secrets of the task are encrypted with the current key
+// of the cipher, before they are decrypted. A disabled cipher leaves every secret current.
+func (t *Task) SecretsCurrent(c Cipher) bool {
+	if !c.Enabled() {
+		return true
+	}
+
+	current := true
+	check := func(secret string) {
+		if !c.Current(secret) {
+			current = false
+		}
+	}
+	t.secrets().clone().walk(
+		func(secret string) (string, error) { check(secret); return secret, nil },
+		func(value any) (any, error) {
+			secret, _ := value.(string)
+			check(secret)
+			return value, nil
+		},
+	)
+	return current
+}
@@ -0,0 +1,133 @@
+package task
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	encryption "github.com/maacarma/scheduler/pkg/encryption"
+)
+
+func TestRedactSensitiveHeaders(t *testing.T) {
+	// the headers decoded from JSON keep the names as sent by the client
+	task := &Task{Headers: http.Header{
+		"authorization":       {"Bearer SECRET"},
+		"Proxy-Authorization": {"Basic SECRET"},
+		"COOKIE":              {"session=SECRET"},
+		"x-Api-key":           {"SECRET"},
+		"content-type":        {"application/json"},
+	}}
+
+	redacted := task.Redact()
+	for name, values := range redacted.Headers {
+		want := Redacted
+		if name == "content-type" {
+			want = "application/json"
+		}
+		if len(values) != 1 || values[0] != want {
+			t.Fatalf("header %s: got %v, want %s", name, values, want)
+		}
+	}
+
+	if task.Headers["authorization"][0] != "Bearer SECRET" {
+		t.Fatal("the task is redacted in place")
+	}
+}
+
+func TestSecretHeadersInAnyCase(t *testing.T) {
+	keyring, err := encryption.New(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := &TaskPayload{
+		Headers: http.Header{
+			"authorization": {"Bearer SECRET"},
+			"x-token":       {"T"},
+			"X-Trace":       {"abc"},
+		},
+		SecretFields: []string{"headers.X-Token"},
+	}
+
+	// the secret headers are stored encrypted
+	encrypted, err := payload.EncryptSecrets(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"authorization", "x-token"} {
+		if value := encrypted.Headers[name][0]; !keyring.Encrypted(value) {
+			t.Fatalf("header %s: stored in plain %q", name, value)
+		}
+	}
+	if value := encrypted.Headers["X-Trace"][0]; value != "abc" {
+		t.Fatalf("header X-Trace: got %q, want it in plain", value)
+	}
+
+	task := encrypted.ConvertToTask("1")
+	if err := task.DecryptSecrets(keyring); err != nil {
+		t.Fatal(err)
+	}
+	if task.Headers["authorization"][0] != "Bearer SECRET" || task.Headers["x-token"][0] != "T" {
+		t.Fatalf("got decrypted headers %v", task.Headers)
+	}
+
+	// and returned redacted
+	redacted := task.Redact()
+	if redacted.Headers["authorization"][0] != Redacted || redacted.Headers["x-token"][0] != Redacted {
+		t.Fatalf("got redacted headers %v", redacted.Headers)
+	}
+
+	// the redacted headers sent back keep their values, whatever the case they are sent in
+	update := &TaskPayload{
+		Headers:      http.Header{"Authorization": {Redacted}, "X-TOKEN": {Redacted}},
+		SecretFields: []string{"headers.x-token"},
+	}
+	update.RestoreSecrets(&task)
+	if update.Headers["Authorization"][0] != "Bearer SECRET" || update.Headers["X-TOKEN"][0] != "T" {
+		t.Fatalf("got restored headers %v", update.Headers)
+	}
+}
+
+func TestValidateSecretValues(t *testing.T) {
+	ciphertext := encryption.Prefix + "k1:AAAA:AAAA"
+
+	tests := []struct {
+		name    string
+		payload *TaskPayload
+		field   string
+	}{
+		{name: "plain secrets", payload: &TaskPayload{
+			Headers: http.Header{"authorization": {"Bearer token"}, "X-Trace": {ciphertext}},
+			Body:    MapAny{"password": "p", "note": ciphertext},
+			Auth:    &Auth{Type: AuthBasic, Username: "u", Password: "p"},
+		}},
+		{name: "sensitive header", payload: &TaskPayload{Headers: http.Header{"authorization": {ciphertext}}}, field: "headers.authorization"},
+		{
+			name:    "secret body value",
+			payload: &TaskPayload{Body: MapAny{"password": ciphertext}, SecretFields: []string{"body.password"}},
+			field:   "body.password",
+		},
+		{name: "auth secret", payload: &TaskPayload{Auth: &Auth{Type: AuthBearer, Token: ciphertext}}, field: "auth.token"},
+		{
+			name:    "signing secret",
+			payload: &TaskPayload{Signing: &Signing{Keys: []SigningKey{{ID: "k1", Secret: "s"}, {ID: "k2", Secret: ciphertext}}}},
+			field:   "signing.keys[1].secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verr := tt.payload.secrets().validateValues()
+			if tt.field == "" {
+				if verr != nil {
+					t.Fatalf("got %v, want no error", verr)
+				}
+				return
+			}
+			if verr == nil || !strings.Contains(verr.Error(), tt.field) {
+				t.Fatalf("got %v, want the error of %s", verr, tt.field)
+			}
+		})
+	}
+}
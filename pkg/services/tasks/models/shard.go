@@ -0,0 +1,10 @@
+package task
+
+// Shard tells whether a task belongs to the shard of a scheduler replica.
+// A nil shard owns every task.
+type Shard func(taskID string) bool
+
+// Owns checks if the task belongs to the shard.
+func (s Shard) Owns(taskID string) bool {
+	return s == nil || s(taskID)
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+
+	errors "github.com/maacarma/scheduler/pkg/errors"
+)
+
+// Signing configures the HMAC-SHA256 signing of a task's requests, see the signing package.
+//
+// The requests are signed with every key in Keys, so that the receivers can rotate
+// to a new key before the old one is removed. A task without keys is signed with
+// the keys of its namespace in the config, if any.
+// Empty header names falls back to those in the config and then to the signing package defaults.
+//
+// The secrets of the keys are redacted in the api responses.
+type Signing struct {
+	Keys            []SigningKey `json:"keys" bson:"keys"`
+	SignatureHeader string       `json:"signature_header,omitempty" bson:"signature_header,omitempty"`
+	TimestampHeader string       `json:"timestamp_header,omitempty" bson:"timestamp_header,omitempty"`
+}
+
+// SigningKey is a signing secret identified by its id, the id is sent along with the signature.
+type SigningKey struct {
+	ID     string `json:"id" bson:"id"`
+	Secret string `json:"secret" bson:"secret"`
+}
+
+// Validate validates the signing.
+func (s *Signing) Validate() *errors.Validation {
+	ids := make(map[string]bool, len(s.Keys))
+	for i, key := range s.Keys {
+		field := fmt.Sprintf("signing.keys[%d]", i)
+		if key.ID == "" {
+			return errors.InvalidPayload(field+".id", errors.RequiredFieldMsg)
+		}
+		// the ids are listed in the signature header as id=signature, separated by commas
+		if strings.ContainsAny(key.ID, "=, ") {
+			return errors.InvalidPayload(field+".id", errors.InvalidFieldMsg, "id should not contain '=', ',' or spaces")
+		}
+		if ids[key.ID] {
+			return errors.InvalidPayload(field+".id", errors.InvalidFieldMsg, "duplicate key id "+key.ID)
+		}
+		ids[key.ID] = true
+
+		if key.Secret == "" {
+			return errors.InvalidPayload(field+".secret", errors.RequiredFieldMsg)
+		}
+	}
+
+	if !validHeader(s.SignatureHeader) {
+		return errors.InvalidPayload("signing.signature_header", errors.InvalidFieldMsg)
+	}
+	if !validHeader(s.TimestampHeader) {
+		return errors.InvalidPayload("signing.timestamp_header", errors.InvalidFieldMsg)
+	}
+
+	return nil
+}
+
+// Restore restores the secrets left redacted from the keys of the same id in the previous signing.
+func (s *Signing) Restore(prev *Signing) {
+	if s == nil || prev == nil {
+		return
+	}
+
+	for i, key := range s.Keys {
+		if key.Secret != Redacted {
+			continue
+		}
+		for _, old := range prev.Keys {
+			if old.ID == key.ID {
+				s.Keys[i].Secret = old.Secret
+			}
+		}
+	}
+}
+
+// validHeader checks if the header name is empty or a valid http header name.
+func validHeader(name string) bool {
+	return !strings.ContainsAny(name, " \t\r\n:()<>@,;\\\"/[]?={}")
+}
@@ -0,0 +1,22 @@
+package task
+
+import "time"
+
+// TaskState is the runtime state of a task in the scheduler process serving the request.
+//
+// Scheduled is set when the task is added to the cron, Pending when it waits
+// for its start time to be added. Next and Prev are the cron entry's next and
+// previous fire times, Skipped is the count of runs skipped by the concurrency policy.
+type TaskState struct {
+	Scheduled bool       `json:"scheduled"`
+	Pending   bool       `json:"pending"`
+	Next      *time.Time `json:"next,omitempty"`
+	Prev      *time.Time `json:"prev,omitempty"`
+	Skipped   int64      `json:"skipped"`
+}
+
+// TaskDetails is a task along with its runtime state.
+type TaskDetails struct {
+	*Task
+	State TaskState `json:"state"`
+}
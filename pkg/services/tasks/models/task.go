@@ -1,12 +1,18 @@
 package task
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
 	"time"
 
 	errors "github.com/maacarma/scheduler/pkg/errors"
 	utils "github.com/maacarma/scheduler/utils"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type MapAny map[string]any
@@ -22,19 +28,65 @@ const (
 
 var methods = []string{GET, POST, PUT, DELETE, PATCH}
 
+// concurrency policies of a task, when a run fires while the previous one is still running
+const (
+	// ConcurrencyAllow runs the new run alongside the previous one.
+	ConcurrencyAllow = "allow"
+	// ConcurrencySkip skips the new run.
+	ConcurrencySkip = "skip"
+	// ConcurrencyQueue runs the new run after the previous one, at most one run waits.
+	ConcurrencyQueue = "queue"
+	// ConcurrencyReplace cancels the previous run and runs the new one.
+	ConcurrencyReplace = "replace"
+)
+
+var concurrencyPolicies = []string{ConcurrencyAllow, ConcurrencySkip, ConcurrencyQueue, ConcurrencyReplace}
+
+// misfire policies of a task, when fire times were missed while the scheduler was down
+const (
+	// MisfireFireOnce runs the task once for the missed fire times, as soon as the scheduler is up.
+	MisfireFireOnce = "fire_once"
+	// MisfireSkip leaves the missed fire times behind, a missed one-shot task is recorded as missed.
+	MisfireSkip = "skip"
+	// MisfireReplayAll runs the task for every missed fire time in order, up to the scheduler's max replays.
+	MisfireReplayAll = "replay_all"
+)
+
+var misfirePolicies = []string{MisfireFireOnce, MisfireSkip, MisfireReplayAll}
+
+// defaults and bounds of the next runs preview
+const (
+	DefaultNextRunsCount = 5
+	MaxNextRunsCount     = 100
+)
+
 // Task represents a task entity.
 type Task struct {
-	ID        string              `json:"_id" bson:"_id"`
-	Url       string              `json:"url" bson:"url"`
-	Method    string              `json:"method" bson:"method"`
-	Namespace string              `json:"namespace" bson:"namespace"`
-	Params    map[string][]string `json:"params" bson:"params"`
-	Headers   http.Header         `json:"headers" bson:"headers"`
-	Body      MapAny              `json:"body" bson:"body"`
-	StartUnix int64               `json:"start_unix" bson:"start_unix"`
-	EndUnix   int64               `json:"end_unix" bson:"end_unix"`
-	Interval  string              `json:"interval" bson:"interval"`
-	Paused    bool                `json:"paused" bson:"paused"`
+	ID                string              `json:"_id" bson:"_id"`
+	Url               string              `json:"url" bson:"url"`
+	Method            string              `json:"method" bson:"method"`
+	Namespace         string              `json:"namespace" bson:"namespace"`
+	Params            map[string][]string `json:"params" bson:"params"`
+	Headers           http.Header         `json:"headers" bson:"headers"`
+	Body              MapAny              `json:"body" bson:"body"`
+	StartUnix         int64               `json:"start_unix" bson:"start_unix"`
+	EndUnix           int64               `json:"end_unix" bson:"end_unix"`
+	Interval          string              `json:"interval" bson:"interval"`
+	Cron              string              `json:"cron" bson:"cron"`
+	Timezone          string              `json:"timezone" bson:"timezone"`
+	Retry             *RetryPolicy        `json:"retry" bson:"retry"`
+	Auth              *Auth               `json:"auth" bson:"auth"`
+	Signing           *Signing            `json:"signing" bson:"signing"`
+	TLSProfile        string              `json:"tls_profile" bson:"tls_profile"`
+	SecretFields      []string            `json:"secret_fields" bson:"secret_fields"`
+	Timeout           string              `json:"timeout" bson:"timeout"`
+	ConcurrencyPolicy string              `json:"concurrency_policy" bson:"concurrency_policy"`
+	MisfirePolicy     string              `json:"misfire_policy" bson:"misfire_policy"`
+	MaxRuns           int64               `json:"max_runs" bson:"max_runs"`
+	Paused            bool                `json:"paused" bson:"paused"`
+	RunCount          int64               `json:"run_count" bson:"run_count"`
+	LastFireUnix      int64               `json:"last_fire_unix" bson:"last_fire_unix"`
+	Completed         bool                `json:"completed" bson:"completed"`
 }
 
 // TaskPayload is the api payload schema for creating a task.
@@ -42,23 +94,88 @@ type Task struct {
 //
 // Interval is a string accepted by time.ParseDuration (http://golang.org/pkg/time/#ParseDuration).
 // if any Interval less than second they will rounded to one second.
+//
+// Cron is a standard cron spec with an optional leading seconds field,
+// or a descriptor like @daily, @hourly. Ex: "0 9 * * 1-5" (every weekday at 09:00).
+// At most one of Interval or Cron should be set, a task with neither is a one-shot task
+// which runs once at the start time and is marked completed afterwards.
+// EndUnix is unused by the one-shot tasks, it defaults to the start time.
+//
+// Timezone is an IANA time zone name (Ex: "Asia/Kolkata", "Europe/Berlin") the cron spec
+// is evaluated in, defaults to UTC, Local isn't accepted. Fire times of a fixed time of the day
+// run at the end of a DST gap and only once in a DST overlap, while the specs firing every hour
+// follow the clock through the gaps and overlaps.
+//
+// Retry is an optional retry policy for the failed executions, see RetryPolicy.
+//
+// Auth is an optional authentication of the task's requests, see Auth.
+// It takes precedence over an Authorization header of the task.
+//
+// Signing optionally signs the task's requests with HMAC-SHA256, see Signing.
+//
+// TLSProfile is the name of a tls profile in the config the task's requests are made with,
+// for the endpoints which use a private CA or require a client certificate.
+//
+// SecretFields marks the headers ("headers.<name>") and the top-level body keys ("body.<key>")
+// holding secrets. The secrets are encrypted at rest when the encryption keys are configured
+// and redacted in the api responses, along with the secrets of the auth and signing
+// and the Authorization, Proxy-Authorization, Cookie and X-Api-Key headers.
+//
+// Timeout is a string accepted by time.ParseDuration, it limits each http request of the task.
+// defaults to the scheduler's timeout in the config.
+//
+// ConcurrencyPolicy is one of the concurrency policies defined above, defaults to allow.
+//
+// MisfirePolicy is one of the misfire policies defined above, it decides what happens to the fire times
+// missed while the scheduler was down, the missed fire times are those after the last scheduled fire time.
+// defaults to fire_once for one-shot tasks (replay_all is the same) and skip for recurring tasks.
+//
+// The url, the params and header values and the string values of the body can hold text/template
+// templates rendered on each attempt, Ex: "{{.ScheduledAt | add \"-24h\" | format \"2006-01-02\"}}".
+// see Task.Render for the variables and the functions.
+//
+// MaxRuns stops a recurring task after the given number of scheduled runs, zero means unlimited.
+// The task is marked completed once the count is reached. Skipped and manual runs aren't counted.
 type TaskPayload struct {
-	Url       string              `json:"url" bson:"url"`
-	Method    string              `json:"method" bson:"method"`
-	Namespace string              `json:"namespace" bson:"namespace"`
-	Params    map[string][]string `json:"params" bson:"params"`
-	Headers   http.Header         `json:"headers" bson:"headers"`
-	Body      MapAny              `json:"body" bson:"body"`
-	StartUnix int64               `json:"start_unix" bson:"start_unix"`
-	EndUnix   int64               `json:"end_unix" bson:"end_unix"`
-	Interval  string              `json:"interval" bson:"interval"`
-	Paused    bool                `json:"paused" bson:"paused"`
+	Url               string              `json:"url" bson:"url"`
+	Method            string              `json:"method" bson:"method"`
+	Namespace         string              `json:"namespace" bson:"namespace"`
+	Params            map[string][]string `json:"params" bson:"params"`
+	Headers           http.Header         `json:"headers" bson:"headers"`
+	Body              MapAny              `json:"body" bson:"body"`
+	StartUnix         int64               `json:"start_unix" bson:"start_unix"`
+	EndUnix           int64               `json:"end_unix" bson:"end_unix"`
+	Interval          string              `json:"interval" bson:"interval"`
+	Cron              string              `json:"cron" bson:"cron"`
+	Timezone          string              `json:"timezone" bson:"timezone"`
+	Retry             *RetryPolicy        `json:"retry" bson:"retry"`
+	Auth              *Auth               `json:"auth" bson:"auth"`
+	Signing           *Signing            `json:"signing" bson:"signing"`
+	TLSProfile        string              `json:"tls_profile" bson:"tls_profile"`
+	SecretFields      []string            `json:"secret_fields" bson:"secret_fields"`
+	Timeout           string              `json:"timeout" bson:"timeout"`
+	ConcurrencyPolicy string              `json:"concurrency_policy" bson:"concurrency_policy"`
+	MisfirePolicy     string              `json:"misfire_policy" bson:"misfire_policy"`
+	MaxRuns           int64               `json:"max_runs" bson:"max_runs"`
+	Paused            bool                `json:"paused" bson:"paused"`
 }
 
 // Validate validates the task payload.
 // checks if the task payload has all the required fields.
-// checks if the task payload has any invalid fields. Ex: http method, interval.
+// checks if the task payload has any invalid fields. Ex: http method, interval, cron.
 func (t *TaskPayload) Validate() *errors.Validation {
+	return t.validate(true)
+}
+
+// ValidateUpdate validates the task payload replacing the given task.
+// start_unix of an already started task can stay in the past as long as it is unchanged.
+func (t *TaskPayload) ValidateUpdate(prev *Task) *errors.Validation {
+	return t.validate(t.StartUnix != prev.StartUnix)
+}
+
+// validate validates the task payload,
+// start_unix is checked to be in the future only if checkStart is set.
+func (t *TaskPayload) validate(checkStart bool) *errors.Validation {
 	if t.Url == "" {
 		return errors.InvalidPayload("url", errors.RequiredFieldMsg)
 	}
@@ -67,20 +184,97 @@ func (t *TaskPayload) Validate() *errors.Validation {
 		return errors.InvalidPayload("method", errors.InvalidFieldMsg)
 	}
 
-	_, err := time.ParseDuration(t.Interval)
-	if err != nil {
-		return errors.InvalidPayload("interval", errors.InvalidFieldMsg, err.Error())
+	switch {
+	case t.Interval == "" && t.Cron == "":
+		// one-shot task
+	case t.Interval != "" && t.Cron != "":
+		return errors.InvalidPayload("cron", errors.InvalidFieldMsg, "interval and cron are mutually exclusive")
+	case t.Interval != "":
+		if _, err := time.ParseDuration(t.Interval); err != nil {
+			return errors.InvalidPayload("interval", errors.InvalidFieldMsg, err.Error())
+		}
+	case strings.HasPrefix(t.Cron, "TZ=") || strings.HasPrefix(t.Cron, "CRON_TZ="):
+		return errors.InvalidPayload("cron", errors.InvalidFieldMsg, "use timezone field instead of TZ prefix")
+	default:
+		if _, err := utils.ParseCron(t.Cron); err != nil {
+			return errors.InvalidPayload("cron", errors.InvalidFieldMsg, err.Error())
+		}
 	}
 
-	_, err = url.Parse(t.Url)
+	// Local is the time zone of the host, the schedules shouldn't depend on where the scheduler runs
+	if t.Timezone == time.Local.String() {
+		return errors.InvalidPayload("timezone", errors.InvalidFieldMsg, "use an IANA time zone name instead of Local")
+	}
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return errors.InvalidPayload("timezone", errors.InvalidFieldMsg, err.Error())
+	}
+
+	if t.Timeout != "" {
+		timeout, err := time.ParseDuration(t.Timeout)
+		if err == nil && timeout <= 0 {
+			err = fmt.Errorf("timeout should be positive")
+		}
+		if err != nil {
+			return errors.InvalidPayload("timeout", errors.InvalidFieldMsg, err.Error())
+		}
+	}
+
+	if t.ConcurrencyPolicy != "" && !utils.Contains(concurrencyPolicies, t.ConcurrencyPolicy) {
+		return errors.InvalidPayload("concurrency_policy", errors.InvalidFieldMsg)
+	}
+
+	if t.MisfirePolicy != "" && !utils.Contains(misfirePolicies, t.MisfirePolicy) {
+		return errors.InvalidPayload("misfire_policy", errors.InvalidFieldMsg)
+	}
+
+	if t.MaxRuns < 0 {
+		return errors.InvalidPayload("max_runs", errors.InvalidFieldMsg, "max_runs should not be negative, 0 means unlimited")
+	}
+
+	if t.Retry != nil {
+		if err := t.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if t.Auth != nil {
+		if err := t.Auth.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if t.Signing != nil {
+		if err := t.Signing.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSecretFields(t.SecretFields); err != nil {
+		return err
+	}
+	if err := t.secrets().validateValues(); err != nil {
+		return err
+	}
+
+	// the url is parsed once rendered, as the templates may span its host
+	sample, verr := t.renderSample()
+	if verr != nil {
+		return verr
+	}
+
+	_, err := url.Parse(sample.url)
 	if err != nil {
 		return errors.InvalidPayload("url", errors.InvalidFieldMsg, err.Error())
 	}
 
-	if utils.Unix(t.StartUnix) < utils.CurrentUTCUnix() {
+	if checkStart && utils.Unix(t.StartUnix) < utils.CurrentUTCUnix() {
 		return errors.InvalidPayload("start_unix", errors.InvalidFieldMsg, "start_unix should be greater than current time")
 	}
 
+	if t.IsOneShot() {
+		return nil
+	}
+
 	if utils.Unix(t.EndUnix) < utils.CurrentUTCUnix() || t.StartUnix > t.EndUnix {
 		return errors.InvalidPayload("end_unix", errors.InvalidFieldMsg)
 	}
@@ -89,17 +283,76 @@ func (t *TaskPayload) Validate() *errors.Validation {
 
 func (t *TaskPayload) ConvertToTask(id string) Task {
 	return Task{
-		ID:        id,
-		Url:       t.Url,
-		Method:    t.Method,
-		Namespace: t.Namespace,
-		Params:    t.Params,
-		Headers:   t.Headers,
-		Body:      t.Body,
-		StartUnix: t.StartUnix,
-		EndUnix:   t.EndUnix,
-		Interval:  t.Interval,
-		Paused:    t.Paused,
+		ID:                id,
+		Url:               t.Url,
+		Method:            t.Method,
+		Namespace:         t.Namespace,
+		Params:            t.Params,
+		Headers:           t.Headers,
+		Body:              t.Body,
+		StartUnix:         t.StartUnix,
+		EndUnix:           t.EndUnix,
+		Interval:          t.Interval,
+		Cron:              t.Cron,
+		Timezone:          t.Timezone,
+		Retry:             t.Retry,
+		Auth:              t.Auth,
+		Signing:           t.Signing,
+		TLSProfile:        t.TLSProfile,
+		SecretFields:      t.SecretFields,
+		Timeout:           t.Timeout,
+		ConcurrencyPolicy: t.ConcurrencyPolicy,
+		MisfirePolicy:     t.MisfirePolicy,
+		MaxRuns:           t.MaxRuns,
+		Paused:            t.Paused,
+	}
+}
+
+// IsOneShot checks if the task has no recurrence.
+func (t *TaskPayload) IsOneShot() bool {
+	return t.Interval == "" && t.Cron == ""
+}
+
+// CompletedAfterUpdate checks if the completed task stays completed once replaced by the payload.
+// A one-shot task is reopened by a new start time or a recurrence, and a recurring task
+// is reopened unless its run count still reaches the max runs.
+func (t *TaskPayload) CompletedAfterUpdate(prev *Task) bool {
+	if !prev.Completed {
+		return false
+	}
+
+	if t.IsOneShot() {
+		return prev.IsOneShot() && t.StartUnix == prev.StartUnix
+	}
+
+	return t.MaxRuns > 0 && prev.RunCount >= t.MaxRuns
+}
+
+// ConvertToPayload converts the task to the api payload schema,
+// the inverse of TaskPayload.ConvertToTask.
+func (t *Task) ConvertToPayload() TaskPayload {
+	return TaskPayload{
+		Url:               t.Url,
+		Method:            t.Method,
+		Namespace:         t.Namespace,
+		Params:            t.Params,
+		Headers:           t.Headers,
+		Body:              t.Body,
+		StartUnix:         t.StartUnix,
+		EndUnix:           t.EndUnix,
+		Interval:          t.Interval,
+		Cron:              t.Cron,
+		Timezone:          t.Timezone,
+		Retry:             t.Retry,
+		Auth:              t.Auth,
+		Signing:           t.Signing,
+		TLSProfile:        t.TLSProfile,
+		SecretFields:      t.SecretFields,
+		Timeout:           t.Timeout,
+		ConcurrencyPolicy: t.ConcurrencyPolicy,
+		MisfirePolicy:     t.MisfirePolicy,
+		MaxRuns:           t.MaxRuns,
+		Paused:            t.Paused,
 	}
 }
 
@@ -115,3 +368,207 @@ func (t *Task) IsActive(curUnix utils.Unix) bool {
 
 	return true
 }
+
+// IsCron checks if the task recurs on a cron spec rather than a fixed interval.
+func (t *Task) IsCron() bool {
+	return t.Cron != ""
+}
+
+// IsOneShot checks if the task runs only once, at the start time.
+func (t *Task) IsOneShot() bool {
+	return t.Interval == "" && t.Cron == ""
+}
+
+// SameDefinition checks if the tasks are defined the same, regardless of their run state
+// (Ex: run count, last fire time). the maps and lists are compared in their normalized form,
+// so that the tasks decoded from mongo, postgres and the api payload compare alike.
+func (t *Task) SameDefinition(other *Task) bool {
+	return t.Url == other.Url &&
+		t.Method == other.Method &&
+		t.Namespace == other.Namespace &&
+		t.StartUnix == other.StartUnix &&
+		t.EndUnix == other.EndUnix &&
+		t.Interval == other.Interval &&
+		t.Cron == other.Cron &&
+		t.Timezone == other.Timezone &&
+		t.TLSProfile == other.TLSProfile &&
+		t.Timeout == other.Timeout &&
+		t.ConcurrencyPolicy == other.ConcurrencyPolicy &&
+		t.MisfirePolicy == other.MisfirePolicy &&
+		t.MaxRuns == other.MaxRuns &&
+		t.Paused == other.Paused &&
+		sameValue(t.Params, other.Params) &&
+		sameValue(t.Headers, other.Headers) &&
+		sameValue(t.Body, other.Body) &&
+		sameValue(t.SecretFields, other.SecretFields) &&
+		reflect.DeepEqual(t.Retry, other.Retry) &&
+		reflect.DeepEqual(t.Auth, other.Auth) &&
+		reflect.DeepEqual(t.Signing, other.Signing)
+}
+
+// sameValue checks if the values are equal once normalized.
+func sameValue(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// normalize converts the maps and lists of a value to map[string]any and []any,
+// the mongo documents (primitive.D) included, and the numbers to float64 as decoded from JSON.
+// the empty maps and lists are normalized to nil.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case primitive.D:
+		return normalize(v.Map())
+	case primitive.A:
+		return normalize([]any(v))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(float64(0))).Interface()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return nil
+		}
+		m := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return m
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return nil
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = normalize(rv.Index(i).Interface())
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+// MissedRuns returns the fire times of the task after its last scheduled fire time until now,
+// at most limit of them, the earliest first.
+func (t *Task) MissedRuns(now time.Time, limit int) ([]time.Time, error) {
+	// a task which never fired missed its start time as well
+	after := time.Unix(t.StartUnix, 0).UTC().Add(-time.Second)
+	if t.LastFireUnix > 0 {
+		after = time.Unix(t.LastFireUnix, 0).UTC()
+	}
+
+	runs, err := t.NextRuns(after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	missed := runs[:0]
+	for _, run := range runs {
+		if run.After(now) {
+			break
+		}
+		missed = append(missed, run)
+	}
+
+	return missed, nil
+}
+
+// RunsExhausted checks if the task has run as many times as its max runs.
+func (t *Task) RunsExhausted() bool {
+	return t.MaxRuns > 0 && t.RunCount >= t.MaxRuns
+}
+
+// Schedule returns the cron schedule of the task.
+// Interval tasks are converted to a constant delay schedule,
+// cron tasks are evaluated in the task's timezone
+// and one-shot tasks fire only at the start time.
+func (t *Task) Schedule() (cron.Schedule, error) {
+	if t.IsOneShot() {
+		return utils.Once(time.Unix(t.StartUnix, 0).UTC()), nil
+	}
+
+	if t.IsCron() {
+		loc, err := time.LoadLocation(t.Timezone)
+		if err != nil {
+			return nil, err
+		}
+
+		return utils.ParseCronIn(t.Cron, loc)
+	}
+
+	interval, err := time.ParseDuration(t.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	return cron.Every(interval), nil
+}
+
+// RequestTimeout returns the timeout of the task's http request,
+// falling back to the given default when the task doesn't set one.
+func (t *Task) RequestTimeout(fallback time.Duration) time.Duration {
+	if timeout, err := time.ParseDuration(t.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+
+	return fallback
+}
+
+// NextRuns returns the next count fire times of the task after the given time,
+// within the start and end time of the task.
+//
+// Interval tasks fires at the start time and then every interval after,
+// cron tasks fires on the times matching the spec after the start time
+// and one-shot tasks fires only at the start time.
+// A completed task has no fire times and the fire times are limited to the runs left as per the max runs.
+func (t *Task) NextRuns(after time.Time, count int) ([]time.Time, error) {
+	if t.Completed {
+		return []time.Time{}, nil
+	}
+
+	if t.MaxRuns > 0 {
+		count = min(count, int(max(t.MaxRuns-t.RunCount, 0)))
+	}
+
+	if t.IsOneShot() {
+		start := time.Unix(t.StartUnix, 0).UTC()
+		if !start.After(after) || count == 0 {
+			return []time.Time{}, nil
+		}
+		return []time.Time{start}, nil
+	}
+
+	schedule, err := t.Schedule()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(t.StartUnix, 0).UTC()
+	end := time.Unix(t.EndUnix, 0).UTC()
+	runs := make([]time.Time, 0, count)
+
+	if delay, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		next := start
+		if !after.Before(start) {
+			next = start.Add((after.Sub(start)/delay.Delay + 1) * delay.Delay)
+		}
+
+		for len(runs) < count && !next.After(end) {
+			runs = append(runs, next)
+			next = next.Add(delay.Delay)
+		}
+		return runs, nil
+	}
+
+	if after.Before(start) {
+		// cron fire times are in seconds, a fire time at the start time is included
+		after = start.Add(-time.Second)
+	}
+	for next := schedule.Next(after); len(runs) < count && !next.IsZero() && !next.After(end); next = schedule.Next(next) {
+		runs = append(runs, next.UTC())
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,89 @@
+package task
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSameDefinition(t *testing.T) {
+	// as decoded from the api payload or postgres json
+	fromJSON := func() *Task {
+		return &Task{
+			ID:      "1",
+			Url:     "https://example.com/hook",
+			Method:  "POST",
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body: MapAny{
+				"user":  map[string]any{"id": float64(7), "tags": []any{"a", "b"}},
+				"count": float64(2),
+			},
+			Params:   map[string][]string{},
+			Interval: "1m",
+		}
+	}
+	// as decoded from mongo, the nested documents and arrays are primitive types
+	fromMongo := func() *Task {
+		return &Task{
+			ID:      "1",
+			Url:     "https://example.com/hook",
+			Method:  "POST",
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body: MapAny{
+				"user":  primitive.D{{Key: "tags", Value: primitive.A{"a", "b"}}, {Key: "id", Value: int32(7)}},
+				"count": int64(2),
+			},
+			Interval: "1m",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(task *Task)
+		want   bool
+	}{
+		{name: "same definition across the decoders", change: func(task *Task) {}, want: true},
+		{
+			name: "run state is ignored",
+			change: func(task *Task) {
+				task.RunCount, task.LastFireUnix, task.Completed = 3, 1700000000, true
+			},
+			want: true,
+		},
+		{
+			name:   "nested body value differs",
+			change: func(task *Task) { task.Body["user"] = primitive.D{{Key: "id", Value: int32(8)}} },
+			want:   false,
+		},
+		{
+			name:   "header differs",
+			change: func(task *Task) { task.Headers.Set("Content-Type", "text/plain") },
+			want:   false,
+		},
+		{
+			name:   "interval differs",
+			change: func(task *Task) { task.Interval = "2m" },
+			want:   false,
+		},
+		{
+			name:   "retry differs",
+			change: func(task *Task) { task.Retry = &RetryPolicy{InitialDelay: "1s"} },
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := fromMongo()
+			tt.change(other)
+
+			if got := fromJSON().SameDefinition(other); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if got := other.SameDefinition(fromJSON()); got != tt.want {
+				t.Fatalf("reversed: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package task
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	errors "github.com/maacarma/scheduler/pkg/errors"
+)
+
+const (
+	renderFailed  = "failed to render %s: %v"
+	invalidEnv    = "invalid env var name %q"
+	invalidSecret = "invalid secret name %q"
+	// the loops and the nested templates could render without bound, Ex: {{range 1000000000}}
+	unsupportedAction = "range and template actions are not supported"
+)
+
+// envName matches the names of the env vars readable by the templates.
+var envName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Time is a time in the templates, printed in RFC 3339.
+// The methods of time.Time are available as well, Ex: {{.ScheduledAt.Unix}}.
+type Time struct {
+	time.Time
+}
+
+func (t Time) String() string {
+	return t.Format(time.RFC3339)
+}
+
+// TemplateVars are the variables of a run of a task available to its templates.
+type TemplateVars struct {
+	TaskID string
+	// RunID is the id of the execution in the execution history, the same across the attempts of the run.
+	// it is empty if the execution couldn't be recorded.
+	RunID string
+	// ScheduledAt is the fire time of the run in UTC, the time the run was triggered at for the manual runs.
+	ScheduledAt Time
+	// Attempt is the number of the attempt, starting at 1.
+	Attempt int
+}
+
+// TemplateLookups look up the values of the env vars and the secrets by name for the templates,
+// the names are validated beforehand.
+type TemplateLookups struct {
+	Env    func(name string) (string, error)
+	Secret func(name string) (string, error)
+}
+
+// templated are the fields of a task's request which can hold templates,
+// the url, the params and header values and the string values of the body at any depth.
+type templated struct {
+	url     string
+	params  map[string][]string
+	headers http.Header
+	body    MapAny
+}
+
+// templateError is the error rendering the template of a field.
+type templateError struct {
+	field string
+	err   error
+}
+
+func (e *templateError) Error() string {
+	return fmt.Sprintf(renderFailed, e.field, e.err)
+}
+
+func (e *templateError) Unwrap() error {
+	return e.err
+}
+
+func (t *Task) templated() templated {
+	return templated{url: t.Url, params: t.Params, headers: t.Headers, body: t.Body}
+}
+
+func (t *TaskPayload) templated() templated {
+	return templated{url: t.Url, params: t.Params, headers: t.Headers, body: t.Body}
+}
+
+// Render returns a copy of the task with the templates of its url, params, headers and body
+// rendered with the variables of the run. The values without templates are left as is.
+//
+// The templates are text/template actions, Ex: "{{.ScheduledAt | add \"-24h\" | format \"2006-01-02\"}}",
+// see TemplateVars for the variables and templateFuncs for the functions.
+func (t *Task) Render(vars TemplateVars, lookups TemplateLookups) (*Task, error) {
+	tmpl, err := t.templated().render(vars, lookups)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := *t
+	rendered.Url, rendered.Params, rendered.Headers, rendered.Body = tmpl.url, tmpl.params, tmpl.headers, tmpl.body
+	return &rendered, nil
+}
+
+// renderSample validates the templates by rendering them with sample variables,
+// the env vars and secrets aren't looked up, their names are rendered instead.
+func (t *TaskPayload) renderSample() (templated, *errors.Validation) {
+	sample := TemplateVars{TaskID: "task", RunID: "run", ScheduledAt: Time{time.Now().UTC()}, Attempt: 1}
+	lookup := func(name string) (string, error) { return name, nil }
+
+	rendered, err := t.templated().render(sample, TemplateLookups{Env: lookup, Secret: lookup})
+	if err, ok := err.(*templateError); ok {
+		return rendered, errors.InvalidPayload(err.field, errors.InvalidFieldMsg, err.err.Error())
+	}
+	return rendered, nil
+}
+
+// render renders the templates into a copy of the fields.
+func (t templated) render(vars TemplateVars, lookups TemplateLookups) (templated, error) {
+	funcs := templateFuncs(lookups)
+	str := func(field, value string) (string, error) {
+		rendered, err := renderString(value, funcs, vars)
+		if err != nil {
+			return "", &templateError{field: field, err: err}
+		}
+		return rendered, nil
+	}
+
+	var err error
+	rendered := templated{}
+	if rendered.url, err = str("url", t.url); err != nil {
+		return rendered, err
+	}
+
+	if t.params != nil {
+		rendered.params = make(map[string][]string, len(t.params))
+		for key, values := range t.params {
+			if rendered.params[key], err = renderStrings("params."+key, values, str); err != nil {
+				return rendered, err
+			}
+		}
+	}
+
+	if t.headers != nil {
+		rendered.headers = make(http.Header, len(t.headers))
+		for name, values := range t.headers {
+			if rendered.headers[name], err = renderStrings("headers."+name, values, str); err != nil {
+				return rendered, err
+			}
+		}
+	}
+
+	if t.body != nil {
+		body, err := renderValue("body", map[string]any(t.body), str)
+		if err != nil {
+			return rendered, err
+		}
+		rendered.body = body.(map[string]any)
+	}
+
+	return rendered, nil
+}
+
+func renderStrings(field string, values []string, str func(string, string) (string, error)) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	rendered := make([]string, len(values))
+	for i, value := range values {
+		var err error
+		if rendered[i], err = str(field, value); err != nil {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
+
+// renderValue renders the string values of a JSON value at any depth, the keys aren't rendered.
+func renderValue(field string, value any, str func(string, string) (string, error)) (any, error) {
+	switch value := value.(type) {
+	case string:
+		return str(field, value)
+
+	case MapAny:
+		return renderValue(field, map[string]any(value), str)
+
+	case map[string]any:
+		rendered := make(map[string]any, len(value))
+		for key, v := range value {
+			r, err := renderValue(field+"."+key, v, str)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+
+	case []any:
+		rendered := make([]any, len(value))
+		for i, v := range value {
+			r, err := renderValue(fmt.Sprintf("%s[%d]", field, i), v, str)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// renderString renders the template in the value, a value without actions is returned as is.
+func renderString(value string, funcs template.FuncMap, vars TemplateVars) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	tmpl, err := template.New("").Funcs(funcs).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if !bounded(tmpl.Root) {
+		return "", fmt.Errorf(unsupportedAction)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// bounded checks if the template has no range or template actions at any depth.
+func bounded(node parse.Node) bool {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return true
+		}
+		for _, n := range node.Nodes {
+			if !bounded(n) {
+				return false
+			}
+		}
+		return true
+	case *parse.IfNode:
+		return bounded(node.List) && bounded(node.ElseList)
+	case *parse.WithNode:
+		return bounded(node.List) && bounded(node.ElseList)
+	case *parse.RangeNode, *parse.TemplateNode:
+		return false
+	default:
+		return true
+	}
+}
+
+// templateFuncs are the functions of the templates:
+//
+//	now                    the current time
+//	add "1h30m" t          t plus the duration, negative durations subtract
+//	addDate y m d t        t plus the years, months and days
+//	truncate "24h" t       t rounded down to a multiple of the duration since the zero time
+//	in "Asia/Kolkata" t    t in the IANA time zone
+//	format "2006-01-02" t  t formatted with the Go layout
+//	unix t, unixMilli t    the unix time of t in seconds or milliseconds
+//	env "NAME"             the env var <prefix>NAME, see the templates config
+//	secret "name"          the secret file <dir>/name, see the templates config
+func templateFuncs(lookups TemplateLookups) template.FuncMap {
+	return template.FuncMap{
+		"now": func() Time { return Time{time.Now().UTC()} },
+		"add": func(duration string, t Time) (Time, error) {
+			d, err := time.ParseDuration(duration)
+			return Time{t.Add(d)}, err
+		},
+		"addDate": func(years, months, days int, t Time) Time {
+			return Time{t.AddDate(years, months, days)}
+		},
+		"truncate": func(duration string, t Time) (Time, error) {
+			d, err := time.ParseDuration(duration)
+			return Time{t.Truncate(d)}, err
+		},
+		"in": func(name string, t Time) (Time, error) {
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return t, err
+			}
+			return Time{t.In(loc)}, nil
+		},
+		"format":    func(layout string, t Time) string { return t.Format(layout) },
+		"unix":      func(t Time) int64 { return t.Unix() },
+		"unixMilli": func(t Time) int64 { return t.UnixMilli() },
+		"env": func(name string) (string, error) {
+			if !envName.MatchString(name) {
+				return "", fmt.Errorf(invalidEnv, name)
+			}
+			return lookups.Env(name)
+		},
+		"secret": func(name string) (string, error) {
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+				return "", fmt.Errorf(invalidSecret, name)
+			}
+			return lookups.Secret(name)
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package task
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renderURL renders the template in the url of a task with the lookups.
+func renderURL(value string, lookups TemplateLookups) (string, error) {
+	vars := TemplateVars{
+		TaskID:      "42",
+		RunID:       "run-7",
+		ScheduledAt: Time{time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+		Attempt:     2,
+	}
+	task := &Task{Url: value}
+	rendered, err := task.Render(vars, lookups)
+	if err != nil {
+		return "", err
+	}
+	return rendered.Url, nil
+}
+
+func TestRenderDateMath(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "{{.ScheduledAt}}", want: "2026-03-01T10:30:00Z"},
+		{template: `{{.ScheduledAt | add "-24h" | format "2006-01-02"}}`, want: "2026-02-28"},
+		{template: `{{.ScheduledAt | add "1h30m"}}`, want: "2026-03-01T12:00:00Z"},
+		{template: `{{.ScheduledAt | addDate 0 -1 0 | format "2006-01-02"}}`, want: "2026-02-01"},
+		{template: `{{.ScheduledAt | addDate 1 0 1 | format "2006-01-02"}}`, want: "2027-03-02"},
+		{template: `{{.ScheduledAt | truncate "1h"}}`, want: "2026-03-01T10:00:00Z"},
+		{template: `{{.ScheduledAt | truncate "24h" | format "2006-01-02T15:04"}}`, want: "2026-03-01T00:00"},
+		{template: `{{.ScheduledAt | in "Asia/Kolkata" | format "15:04 -07:00"}}`, want: "16:00 +05:30"},
+		{template: "{{.ScheduledAt | unix}}", want: "1772361000"},
+		{template: "{{.ScheduledAt | unixMilli}}", want: "1772361000000"},
+		{template: "{{.ScheduledAt.Unix}}", want: "1772361000"},
+		{template: "https://example.com/{{.TaskID}}/{{.RunID}}?attempt={{.Attempt}}", want: "https://example.com/42/run-7?attempt=2"},
+		{template: "no actions", want: "no actions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := renderURL(tt.template, TemplateLookups{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, template := range []string{`{{.ScheduledAt | add "1x"}}`, `{{.ScheduledAt | in "Mars/Olympus"}}`} {
+		if _, err := renderURL(template, TemplateLookups{}); err == nil {
+			t.Fatalf("%s: want an error", template)
+		}
+	}
+}
+
+func TestRenderSecretPaths(t *testing.T) {
+	var looked []string
+	lookups := TemplateLookups{Secret: func(name string) (string, error) {
+		looked = append(looked, name)
+		return "value-of-" + name, nil
+	}}
+
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "nested/token", `..\token`, "..", ".", ""} {
+		t.Run(name, func(t *testing.T) {
+			_, err := renderURL(`{{secret "`+strings.ReplaceAll(name, `\`, `\\`)+`"}}`, lookups)
+			if err == nil || !strings.Contains(err.Error(), "invalid secret name") {
+				t.Fatalf("got %v, want the invalid secret name error", err)
+			}
+		})
+	}
+	if len(looked) != 0 {
+		t.Fatalf("looked up the rejected secrets %v", looked)
+	}
+
+	got, err := renderURL(`{{secret "api-token"}}`, lookups)
+	if err != nil || got != "value-of-api-token" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestRenderEnvNames(t *testing.T) {
+	lookups := TemplateLookups{Env: func(name string) (string, error) { return "value-of-" + name, nil }}
+
+	if got, err := renderURL(`{{env "API_TOKEN"}}`, lookups); err != nil || got != "value-of-API_TOKEN" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	for _, name := range []string{"API-TOKEN", "../PATH", ""} {
+		if _, err := renderURL(`{{env "`+name+`"}}`, lookups); err == nil || !strings.Contains(err.Error(), "invalid env var name") {
+			t.Fatalf("%q: got %v, want the invalid env var name error", name, err)
+		}
+	}
+}
+
+func TestRenderBlockedActions(t *testing.T) {
+	for _, template := range []string{
+		"{{range 1000000000}}x{{end}}",
+		`{{range $i, $c := "abc"}}{{$c}}{{end}}`,
+		`{{define "loop"}}x{{end}}{{template "loop"}}`,
+		"{{if .Attempt}}{{range 3}}x{{end}}{{end}}",
+		"{{if .RunID}}ok{{else}}{{range 3}}x{{end}}{{end}}",
+		"{{with .TaskID}}{{range 3}}x{{end}}{{end}}",
+	} {
+		t.Run(template, func(t *testing.T) {
+			_, err := renderURL(template, TemplateLookups{})
+			if err == nil || !strings.Contains(err.Error(), unsupportedAction) {
+				t.Fatalf("got %v, want %q", err, unsupportedAction)
+			}
+
+			var tmplErr *templateError
+			if !errors.As(err, &tmplErr) || tmplErr.field != "url" {
+				t.Fatalf("got %v, want the error of the url field", err)
+			}
+		})
+	}
+
+	// the templates are validated on the payload as well
+	payload := &TaskPayload{Url: "https://example.com", Body: MapAny{"items": []any{map[string]any{"id": "{{range 3}}x{{end}}"}}}}
+	if _, verr := payload.renderSample(); verr == nil {
+		t.Fatal("want a validation error of the body")
+	}
+}
@@ -0,0 +1,88 @@
+package mongodb
+
+import (
+	"context"
+
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type executionRepo struct {
+	client *mongo.Client
+	db     string
+	col    string
+}
+
+// NewExecutions returns a new instance of the mongodb execution history repo.
+// the executions are indexed by the task and the scheduled time, as they are listed by GetExecutions.
+func NewExecutions(ctx context.Context, client *mongo.Client) (*executionRepo, error) {
+	r := &executionRepo{client: client, db: "scheduler", col: "executions"}
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "task_id", Value: 1}, {Key: "scheduled_at", Value: -1}},
+	}
+	if _, err := client.Database(r.db).Collection(r.col).Indexes().CreateOne(ctx, index); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CreateExecution records an execution and returns the id.
+func (r *executionRepo) CreateExecution(ctx context.Context, execution *models.Execution) (string, error) {
+	collection := r.client.Database(r.db).Collection(r.col)
+	res, err := collection.InsertOne(ctx, execution)
+	if err != nil {
+		return "", err
+	}
+
+	return res.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// UpdateExecution updates the outcome of a recorded execution.
+func (r *executionRepo) UpdateExecution(ctx context.Context, execution *models.Execution) error {
+	collection := r.client.Database(r.db).Collection(r.col)
+	update := bson.M{"$set": bson.M{
+		"status":        execution.Status,
+		"ended_at":      execution.EndedAt,
+		"attempt":       execution.Attempt,
+		"status_code":   execution.StatusCode,
+		"latency_ms":    execution.LatencyMs,
+		"response_body": execution.ResponseBody,
+		"error":         execution.Error,
+		"attempts":      execution.Attempts,
+	}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": objectID(execution.ID)}, update)
+	return err
+}
+
+// GetExecutions returns the executions of a task scheduled in the filter's time range,
+// latest first.
+func (r *executionRepo) GetExecutions(ctx context.Context, taskID string, filter models.ExecutionFilter) ([]*models.Execution, error) {
+	collection := r.client.Database(r.db).Collection(r.col)
+	query := bson.M{
+		"task_id":      taskID,
+		"scheduled_at": bson.M{"$gte": filter.Since, "$lt": filter.Until},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "scheduled_at", Value: -1}}).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit))
+
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	executions := []*models.Execution{}
+	if err := cursor.All(ctx, &executions); err != nil {
+		return nil, err
+	}
+
+	return executions, nil
+}
@@ -3,23 +3,32 @@ package mongodb
 import (
 	"context"
 
+	errors "github.com/maacarma/scheduler/pkg/errors"
 	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
 	utils "github.com/maacarma/scheduler/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// secretFields are the fields of the task documents holding the secrets.
+var secretFields = []string{"headers", "body", "auth", "signing"}
+
+// repo is the concrete implementation of the Tasks Repo interface.
+// The secrets of the tasks are encrypted by the cipher before they are stored
+// and decrypted once read, so that the callers only see them in plain.
 type repo struct {
 	client *mongo.Client
+	cipher models.Cipher
 	db     string
 	col    string
 }
 
 // New returns a new instance of the postgres repo.
-func New(client *mongo.Client) *repo {
-	return &repo{client: client, db: "scheduler", col: "tasks"}
+func New(client *mongo.Client, cipher models.Cipher) *repo {
+	return &repo{client: client, cipher: cipher, db: "scheduler", col: "tasks"}
 }
 
 // GetAll returns all tasks from the database.
@@ -36,7 +45,7 @@ func (r *repo) GetAll(ctx context.Context) ([]*models.Task, error) {
 		return nil, err
 	}
 
-	return tasks, nil
+	return tasks, r.decrypt(tasks...)
 }
 
 // GetByNamespace returns all tasks from the database with the given namespace.
@@ -52,40 +61,113 @@ func (r *repo) GetByNamespace(ctx context.Context, namespace string) ([]*models.
 	if err := cursor.All(ctx, &tasks); err != nil {
 		return nil, err
 	}
-	return tasks, nil
+	return tasks, r.decrypt(tasks...)
 }
 
 // GetByID returns a task from the database with the given id.
 func (r *repo) GetByID(ctx context.Context, id string) (*models.Task, error) {
 	collection := r.client.Database(r.db).Collection(r.col)
 	task := &models.Task{}
-	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(task)
+	err := collection.FindOne(ctx, bson.M{"_id": objectID(id)}).Decode(task)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.ErrTaskNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return task, nil
+	return task, r.decrypt(task)
 }
 
-// GetActiveTasks returns a list of active tasks
-func (r *repo) GetActiveTasks(ctx context.Context, curUnix utils.Unix) ([]*models.Task, error) {
+// GetActiveTasks returns the unexpired, unpaused and uncompleted tasks of the shard.
+// the shard is a consistent hash ring of the task ids, which mongo can't evaluate, so the ids of
+// the active tasks are filtered first and only the documents of the shard are read and decrypted.
+// the tasks which can't be decoded or decrypted are skipped, reported by a models.SkippedTasksError.
+func (r *repo) GetActiveTasks(ctx context.Context, curUnix utils.Unix, shard models.Shard) ([]*models.Task, error) {
 	collection := r.client.Database(r.db).Collection(r.col)
-	cursor, err := collection.Find(ctx, bson.M{"paused": false, "end_unix": bson.M{"$gte": curUnix}})
+	filter := bson.M{
+		"paused":    false,
+		"completed": bson.M{"$ne": true},
+		// one-shot tasks are active until they run, regardless of the end time
+		"$or": bson.A{
+			bson.M{"end_unix": bson.M{"$gte": curUnix}},
+			bson.M{"interval": "", "cron": ""},
+		},
+	}
+	if shard != nil {
+		owned, err := r.activeIDsOf(ctx, filter, shard)
+		if err != nil {
+			return nil, err
+		}
+		if len(owned) == 0 {
+			return []*models.Task{}, nil
+		}
+		filter["_id"] = bson.M{"$in": owned}
+	}
+
+	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
 	tasks := []*models.Task{}
-	if err := cursor.All(ctx, &tasks); err != nil {
-		return nil, err
+	var skipped []error
+	for cursor.Next(ctx) {
+		task := &models.Task{}
+		if err := cursor.Decode(task); err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+
+		if err := r.decrypt(task); err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		tasks = append(tasks, task)
 	}
 
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if len(skipped) > 0 {
+		return tasks, &models.SkippedTasksError{Errs: skipped}
+	}
 	return tasks, nil
 }
 
+// activeIDsOf returns the ids of the tasks matching the filter which are owned by the shard.
+func (r *repo) activeIDsOf(ctx context.Context, filter bson.M, shard models.Shard) ([]primitive.ObjectID, error) {
+	collection := r.client.Database(r.db).Collection(r.col)
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	owned := []primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if shard.Owns(doc.ID.Hex()) {
+			owned = append(owned, doc.ID)
+		}
+	}
+
+	return owned, cursor.Err()
+}
+
 // CreateOne creates a new task and returns the id.
 func (r *repo) CreateOne(ctx context.Context, task *models.TaskPayload) (string, error) {
+	task, err := task.EncryptSecrets(r.cipher)
+	if err != nil {
+		return "", err
+	}
+
 	collection := r.client.Database(r.db).Collection(r.col)
 	res, err := collection.InsertOne(ctx, task)
 	if err != nil {
@@ -99,13 +181,147 @@ func (r *repo) CreateOne(ctx context.Context, task *models.TaskPayload) (string,
 func (r *repo) UpdateStatus(ctx context.Context, id string, paused bool) error {
 	collection := r.client.Database(r.db).Collection(r.col)
 
-	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"paused": paused}})
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": objectID(id)}, bson.M{"$set": bson.M{"paused": paused}})
+	return err
+}
+
+// Update replaces the definition of a task.
+func (r *repo) Update(ctx context.Context, id string, task *models.TaskPayload, completed bool) error {
+	task, err := task.EncryptSecrets(r.cipher)
+	if err != nil {
+		return err
+	}
+
+	// the completed state is set along with the definition, so that the change is seen at once
+	doc, err := bson.Marshal(task)
+	if err != nil {
+		return err
+	}
+	var set bson.D
+	if err := bson.Unmarshal(doc, &set); err != nil {
+		return err
+	}
+	set = append(set, bson.E{Key: "completed", Value: completed})
+
+	collection := r.client.Database(r.db).Collection(r.col)
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID(id)}, bson.M{"$set": set})
+	return err
+}
+
+// Complete marks a task as completed, a completed task is no longer scheduled.
+func (r *repo) Complete(ctx context.Context, id string) error {
+	collection := r.client.Database(r.db).Collection(r.col)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": objectID(id)}, bson.M{"$set": bson.M{"completed": true}})
+	return err
+}
+
+// IncrementRunCount atomically increments the run count of a task and returns it.
+func (r *repo) IncrementRunCount(ctx context.Context, id string) (int64, error) {
+	collection := r.client.Database(r.db).Collection(r.col)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	task := &models.Task{}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID(id)}, bson.M{"$inc": bson.M{"run_count": 1}}, opts).Decode(task)
+	if err == mongo.ErrNoDocuments {
+		return 0, errors.ErrTaskNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return task.RunCount, nil
+}
+
+// UpdateLastFire updates the last scheduled fire time of a task, it never moves backwards.
+func (r *repo) UpdateLastFire(ctx context.Context, id string, fireUnix int64) error {
+	collection := r.client.Database(r.db).Collection(r.col)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": objectID(id)}, bson.M{"$max": bson.M{"last_fire_unix": fireUnix}})
 	return err
 }
 
 // Delete deletes a task
 func (r *repo) Delete(ctx context.Context, id string) error {
 	collection := r.client.Database(r.db).Collection(r.col)
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": objectID(id)})
 	return err
 }
+
+// RotateSecrets re-encrypts the secrets of the tasks which aren't encrypted with the current key
+// of the cipher, including the secrets stored in plain. It returns the count of the tasks re-encrypted.
+// A task updated meanwhile is left as is, its update encrypts it with the current key anyway.
+func (r *repo) RotateSecrets(ctx context.Context) (int, error) {
+	collection := r.client.Database(r.db).Collection(r.col)
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rotated := 0
+	for cursor.Next(ctx) {
+		task := &models.Task{}
+		if err := cursor.Decode(task); err != nil {
+			return rotated, err
+		}
+		if task.SecretsCurrent(r.cipher) {
+			continue
+		}
+
+		// the secrets are replaced only if they are still those read
+		filter := bson.D{{Key: "_id", Value: cursor.Current.Lookup("_id")}}
+		for _, field := range secretFields {
+			value, err := cursor.Current.LookupErr(field)
+			if err != nil {
+				filter = append(filter, bson.E{Key: field, Value: bson.M{"$exists": false}})
+				continue
+			}
+			filter = append(filter, bson.E{Key: field, Value: value})
+		}
+
+		if err := task.DecryptSecrets(r.cipher); err != nil {
+			return rotated, err
+		}
+		payload := task.ConvertToPayload()
+		encrypted, err := payload.EncryptSecrets(r.cipher)
+		if err != nil {
+			return rotated, err
+		}
+
+		update := bson.M{"$set": bson.M{
+			"headers": encrypted.Headers,
+			"body":    encrypted.Body,
+			"auth":    encrypted.Auth,
+			"signing": encrypted.Signing,
+		}}
+		res, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return rotated, err
+		}
+		rotated += int(res.ModifiedCount)
+	}
+
+	return rotated, cursor.Err()
+}
+
+// decrypt decrypts the secrets of the tasks read.
+func (r *repo) decrypt(tasks ...*models.Task) error {
+	for _, task := range tasks {
+		if err := task.DecryptSecrets(r.cipher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectID converts the hex id returned by CreateOne back to an ObjectID,
+// ids which aren't ObjectIDs are matched as they are.
+func objectID(id string) any {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return id
+	}
+
+	return oid
+}
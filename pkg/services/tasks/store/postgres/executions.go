@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	errors "github.com/maacarma/scheduler/pkg/errors"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	sqlgen "github.com/maacarma/scheduler/pkg/services/tasks/store/postgres/sqlgen"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// executionRepo is the concrete implementation of the tasks ExecutionRepo interface.
+type executionRepo struct {
+	querier sqlgen.Querier
+}
+
+// NewExecutions returns a new instance of the postgres execution history repo.
+func NewExecutions(pgConn *pgxpool.Pool) *executionRepo {
+	querier := sqlgen.New(pgConn)
+	return &executionRepo{querier: querier}
+}
+
+// CreateExecution records an execution and returns the id.
+func (r *executionRepo) CreateExecution(ctx context.Context, execution *models.Execution) (string, error) {
+	taskID, err := strconv.ParseInt(execution.TaskID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	attemptsInBytes, err := json.Marshal(execution.Attempts)
+	if err != nil {
+		return "", err
+	}
+
+	m := sqlgen.CreateExecutionParams{
+		TaskID:       taskID,
+		Status:       execution.Status,
+		ScheduledAt:  timestamptz(execution.ScheduledAt),
+		StartedAt:    timestamptz(execution.StartedAt),
+		EndedAt:      timestamptz(execution.EndedAt),
+		Attempt:      int32(execution.Attempt),
+		StatusCode:   int32(execution.StatusCode),
+		LatencyMs:    execution.LatencyMs,
+		ResponseBody: execution.ResponseBody,
+		Error:        execution.Error,
+		Attempts:     attemptsInBytes,
+		Trigger:      execution.Trigger,
+	}
+
+	id, err := r.querier.CreateExecution(ctx, m)
+	return fmt.Sprint(id), err
+}
+
+// UpdateExecution updates the outcome of a recorded execution.
+func (r *executionRepo) UpdateExecution(ctx context.Context, execution *models.Execution) error {
+	id, err := strconv.ParseInt(execution.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	attemptsInBytes, err := json.Marshal(execution.Attempts)
+	if err != nil {
+		return err
+	}
+
+	args := sqlgen.UpdateExecutionParams{
+		ID:           id,
+		Status:       execution.Status,
+		EndedAt:      timestamptz(execution.EndedAt),
+		Attempt:      int32(execution.Attempt),
+		StatusCode:   int32(execution.StatusCode),
+		LatencyMs:    execution.LatencyMs,
+		ResponseBody: execution.ResponseBody,
+		Error:        execution.Error,
+		Attempts:     attemptsInBytes,
+	}
+
+	return r.querier.UpdateExecution(ctx, args)
+}
+
+// GetExecutions returns the executions of a task scheduled in the filter's time range,
+// latest first.
+func (r *executionRepo) GetExecutions(ctx context.Context, taskIDStr string, filter models.ExecutionFilter) ([]*models.Execution, error) {
+	taskID, err := strconv.ParseInt(taskIDStr, 10, 64)
+	if err != nil {
+		return nil, errors.ErrTaskNotFound
+	}
+
+	args := sqlgen.GetExecutionsByTaskParams{
+		TaskID:    taskID,
+		Since:     timestamptz(filter.Since),
+		Until:     timestamptz(filter.Until),
+		RowLimit:  int32(filter.Limit),
+		RowOffset: int32(filter.Offset),
+	}
+	executions, err := r.querier.GetExecutionsByTask(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.Execution, 0)
+	for _, execution := range executions {
+		e, err := convertExecution(execution)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+// convertExecution converts a sqlgen execution to a native execution model.
+func convertExecution(execution *sqlgen.Execution) (*models.Execution, error) {
+	var e models.Execution
+	if len(execution.Attempts) > 0 {
+		err := json.Unmarshal(execution.Attempts, &e.Attempts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.ID = fmt.Sprint(execution.ID)
+	e.TaskID = fmt.Sprint(execution.TaskID)
+	e.Status = execution.Status
+	e.ScheduledAt = execution.ScheduledAt.Time.UTC()
+	e.StartedAt = execution.StartedAt.Time.UTC()
+	e.EndedAt = execution.EndedAt.Time.UTC()
+	e.Attempt = int(execution.Attempt)
+	e.StatusCode = int(execution.StatusCode)
+	e.LatencyMs = execution.LatencyMs
+	e.ResponseBody = execution.ResponseBody
+	e.Error = execution.Error
+	e.Trigger = execution.Trigger
+
+	return &e, nil
+}
+
+// timestamptz converts the time to a postgres timestamptz.
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
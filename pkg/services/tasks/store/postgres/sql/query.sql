@@ -5,15 +5,25 @@ SELECT * FROM tasks;
 SELECT * FROM tasks
 WHERE namespace = $1;
 
+-- name: GetActiveTaskIDs :many
+SELECT _id FROM tasks
+WHERE (end_unix >= $1 OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed;
+
 -- name: GetActiveTasks :many
 SELECT * FROM tasks
-WHERE end_unix >= $1 AND NOT paused;
+WHERE (end_unix >= $1 OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed;
+
+-- name: GetActiveTasksByIDs :many
+SELECT * FROM tasks
+WHERE (end_unix >= @end_unix OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed
+  AND _id = ANY(@ids::bigint[]);
 
 -- name: CreateTask :one
 INSERT INTO tasks (
-  url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused
+  url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout,
+  concurrency_policy, misfire_policy, max_runs, auth, signing, tls_profile, secret_fields
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
 )
 RETURNING _id;
 
@@ -26,6 +36,62 @@ UPDATE tasks
 SET paused = $2
 WHERE _id = $1;
 
+-- name: UpdateTask :exec
+UPDATE tasks
+SET url = $2, method = $3, namespace = $4, params = $5, headers = $6, body = $7,
+  start_unix = $8, end_unix = $9, interval = $10, paused = $11, cron = $12, timezone = $13,
+  retry = $14, timeout = $15, concurrency_policy = $16, misfire_policy = $17, max_runs = $18,
+  auth = $19, signing = $20, tls_profile = $21, secret_fields = $22, completed = $23
+WHERE _id = $1;
+
+-- name: UpdateTaskSecrets :execrows
+UPDATE tasks
+SET headers = sqlc.arg(headers), body = sqlc.arg(body), auth = sqlc.arg(auth), signing = sqlc.arg(signing)
+WHERE _id = sqlc.arg(id)
+  AND headers::text IS NOT DISTINCT FROM sqlc.narg(old_headers)::text
+  AND body::text IS NOT DISTINCT FROM sqlc.narg(old_body)::text
+  AND auth::text IS NOT DISTINCT FROM sqlc.narg(old_auth)::text
+  AND signing::text IS NOT DISTINCT FROM sqlc.narg(old_signing)::text;
+
+-- name: CompleteTask :exec
+UPDATE tasks
+SET completed = TRUE
+WHERE _id = $1;
+
+-- name: IncrementRunCount :one
+UPDATE tasks
+SET run_count = run_count + 1
+WHERE _id = $1
+RETURNING run_count;
+
+-- name: UpdateLastFire :exec
+UPDATE tasks
+SET last_fire_unix = GREATEST(last_fire_unix, sqlc.arg(last_fire_unix)::bigint)
+WHERE _id = sqlc.arg(id);
+
 -- name: DeleteTask :exec
 DELETE FROM tasks
-WHERE _id = $1;
\ No newline at end of file
+WHERE _id = $1;
+
+-- name: CreateExecution :one
+INSERT INTO executions (
+  task_id, status, scheduled_at, started_at, ended_at, attempt, status_code, latency_ms, response_body, error, attempts,
+  trigger
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+)
+RETURNING _id;
+
+-- name: UpdateExecution :exec
+UPDATE executions
+SET status = $2, ended_at = $3, attempt = $4, status_code = $5, latency_ms = $6, response_body = $7,
+  error = $8, attempts = $9
+WHERE _id = $1;
+
+-- name: GetExecutionsByTask :many
+SELECT * FROM executions
+WHERE task_id = sqlc.arg(task_id)
+  AND scheduled_at >= sqlc.arg(since)
+  AND scheduled_at < sqlc.arg(until)
+ORDER BY scheduled_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
\ No newline at end of file
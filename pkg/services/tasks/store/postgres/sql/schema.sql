@@ -10,4 +10,78 @@ CREATE TABLE IF NOT EXISTS tasks (
   end_unix        bigint   NOT NULL CHECK (end_unix >= 0),
   interval        text     NOT NULL,
   paused          boolean  NOT NULL DEFAULT FALSE
-);
\ No newline at end of file
+);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS cron text NOT NULL DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT 'UTC';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS retry json;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS timeout text NOT NULL DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS concurrency_policy text NOT NULL DEFAULT 'allow';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS misfire_policy text NOT NULL DEFAULT 'skip';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed boolean NOT NULL DEFAULT FALSE;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS max_runs bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS run_count bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS last_fire_unix bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS auth json;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS signing json;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS tls_profile text NOT NULL DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS secret_fields json;
+
+CREATE TABLE IF NOT EXISTS executions (
+  _id             BIGSERIAL   PRIMARY KEY,
+  task_id         bigint      NOT NULL REFERENCES tasks (_id) ON DELETE CASCADE,
+  status          text        NOT NULL,
+  scheduled_at    timestamptz NOT NULL,
+  started_at      timestamptz NOT NULL,
+  ended_at        timestamptz NOT NULL,
+  attempt         integer     NOT NULL,
+  status_code     integer     NOT NULL,
+  latency_ms      bigint      NOT NULL,
+  response_body   text        NOT NULL,
+  error           text        NOT NULL,
+  attempts        json
+);
+
+CREATE INDEX IF NOT EXISTS executions_task_id_scheduled_at_idx ON executions (task_id, scheduled_at DESC);
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS trigger text NOT NULL DEFAULT 'scheduled';
+
+CREATE TABLE IF NOT EXISTS members (
+  id              text        PRIMARY KEY,
+  expires_at      timestamptz NOT NULL
+);
+
+-- notifies the replicas of the task changes, except the updates of the run state of a task
+CREATE OR REPLACE FUNCTION notify_task_change() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('task_changes', json_build_object('op', 'delete', 'id', OLD._id::text)::text);
+  ELSE
+    PERFORM pg_notify('task_changes', json_build_object('op', 'upsert', 'id', NEW._id::text)::text);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the trigger is recreated on every start, so that its column list follows the tasks table.
+-- the schema is initialized in a transaction holding an advisory lock,
+-- so the replicas starting together don't race, and no change goes unnotified in between.
+DROP TRIGGER IF EXISTS tasks_notify_change ON tasks;
+CREATE TRIGGER tasks_notify_change
+AFTER INSERT OR DELETE OR UPDATE OF url, method, namespace, params, headers, body, start_unix, end_unix,
+  interval, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, max_runs, paused, auth, signing, tls_profile, secret_fields
+ON tasks
+FOR EACH ROW EXECUTE FUNCTION notify_task_change();
@@ -4,16 +4,50 @@
 
 package sqlgen
 
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Execution struct {
+	ID           int64              `json:"_id"`
+	TaskID       int64              `json:"task_id"`
+	Status       string             `json:"status"`
+	ScheduledAt  pgtype.Timestamptz `json:"scheduled_at"`
+	StartedAt    pgtype.Timestamptz `json:"started_at"`
+	EndedAt      pgtype.Timestamptz `json:"ended_at"`
+	Attempt      int32              `json:"attempt"`
+	StatusCode   int32              `json:"status_code"`
+	LatencyMs    int64              `json:"latency_ms"`
+	ResponseBody string             `json:"response_body"`
+	Error        string             `json:"error"`
+	Attempts     []byte             `json:"attempts"`
+	Trigger      string             `json:"trigger"`
+}
+
 type Task struct {
-	ID        int64  `json:"_id"`
-	Url       string `json:"url"`
-	Method    string `json:"method"`
-	Namespace string `json:"namespace"`
-	Params    []byte `json:"params"`
-	Headers   []byte `json:"headers"`
-	Body      []byte `json:"body"`
-	StartUnix int64  `json:"start_unix"`
-	EndUnix   int64  `json:"end_unix"`
-	Interval  string `json:"interval"`
-	Paused    bool   `json:"paused"`
+	ID                int64  `json:"_id"`
+	Url               string `json:"url"`
+	Method            string `json:"method"`
+	Namespace         string `json:"namespace"`
+	Params            []byte `json:"params"`
+	Headers           []byte `json:"headers"`
+	Body              []byte `json:"body"`
+	StartUnix         int64  `json:"start_unix"`
+	EndUnix           int64  `json:"end_unix"`
+	Interval          string `json:"interval"`
+	Paused            bool   `json:"paused"`
+	Cron              string `json:"cron"`
+	Timezone          string `json:"timezone"`
+	Retry             []byte `json:"retry"`
+	Timeout           string `json:"timeout"`
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	MisfirePolicy     string `json:"misfire_policy"`
+	Completed         bool   `json:"completed"`
+	MaxRuns           int64  `json:"max_runs"`
+	RunCount          int64  `json:"run_count"`
+	LastFireUnix      int64  `json:"last_fire_unix"`
+	Auth              []byte `json:"auth"`
+	Signing           []byte `json:"signing"`
+	TlsProfile        string `json:"tls_profile"`
+	SecretFields      []byte `json:"secret_fields"`
 }
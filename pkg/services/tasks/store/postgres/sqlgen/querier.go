@@ -9,12 +9,22 @@ import (
 )
 
 type Querier interface {
+	CompleteTask(ctx context.Context, ID int64) error
+	CreateExecution(ctx context.Context, arg CreateExecutionParams) (int64, error)
 	CreateTask(ctx context.Context, arg CreateTaskParams) (int64, error)
 	DeleteTask(ctx context.Context, ID int64) error
+	GetActiveTaskIDs(ctx context.Context, endUnix int64) ([]int64, error)
 	GetActiveTasks(ctx context.Context, endUnix int64) ([]*Task, error)
+	GetActiveTasksByIDs(ctx context.Context, arg GetActiveTasksByIDsParams) ([]*Task, error)
+	GetExecutionsByTask(ctx context.Context, arg GetExecutionsByTaskParams) ([]*Execution, error)
 	GetTaskByID(ctx context.Context, ID int64) (*Task, error)
 	GetTasks(ctx context.Context) ([]*Task, error)
 	GetTasksByNamespace(ctx context.Context, namespace string) ([]*Task, error)
+	IncrementRunCount(ctx context.Context, ID int64) (int64, error)
+	UpdateExecution(ctx context.Context, arg UpdateExecutionParams) error
+	UpdateLastFire(ctx context.Context, arg UpdateLastFireParams) error
+	UpdateTask(ctx context.Context, arg UpdateTaskParams) error
+	UpdateTaskSecrets(ctx context.Context, arg UpdateTaskSecretsParams) (int64, error)
 	UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusParams) error
 }
 
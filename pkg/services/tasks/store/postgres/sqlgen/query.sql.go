@@ -7,28 +7,98 @@ package sqlgen
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const completeTask = `-- name: CompleteTask :exec
+UPDATE tasks
+SET completed = TRUE
+WHERE _id = $1
+`
+
+func (q *Queries) CompleteTask(ctx context.Context, ID int64) error {
+	_, err := q.db.Exec(ctx, completeTask, ID)
+	return err
+}
+
+const createExecution = `-- name: CreateExecution :one
+INSERT INTO executions (
+  task_id, status, scheduled_at, started_at, ended_at, attempt, status_code, latency_ms, response_body, error, attempts,
+  trigger
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+)
+RETURNING _id
+`
+
+type CreateExecutionParams struct {
+	TaskID       int64              `json:"task_id"`
+	Status       string             `json:"status"`
+	ScheduledAt  pgtype.Timestamptz `json:"scheduled_at"`
+	StartedAt    pgtype.Timestamptz `json:"started_at"`
+	EndedAt      pgtype.Timestamptz `json:"ended_at"`
+	Attempt      int32              `json:"attempt"`
+	StatusCode   int32              `json:"status_code"`
+	LatencyMs    int64              `json:"latency_ms"`
+	ResponseBody string             `json:"response_body"`
+	Error        string             `json:"error"`
+	Attempts     []byte             `json:"attempts"`
+	Trigger      string             `json:"trigger"`
+}
+
+func (q *Queries) CreateExecution(ctx context.Context, arg CreateExecutionParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createExecution,
+		arg.TaskID,
+		arg.Status,
+		arg.ScheduledAt,
+		arg.StartedAt,
+		arg.EndedAt,
+		arg.Attempt,
+		arg.StatusCode,
+		arg.LatencyMs,
+		arg.ResponseBody,
+		arg.Error,
+		arg.Attempts,
+		arg.Trigger,
+	)
+	var _id int64
+	err := row.Scan(&_id)
+	return _id, err
+}
+
 const createTask = `-- name: CreateTask :one
 INSERT INTO tasks (
-  url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused
+  url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout,
+  concurrency_policy, misfire_policy, max_runs, auth, signing, tls_profile, secret_fields
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
 )
 RETURNING _id
 `
 
 type CreateTaskParams struct {
-	Url       string `json:"url"`
-	Method    string `json:"method"`
-	Namespace string `json:"namespace"`
-	Params    []byte `json:"params"`
-	Headers   []byte `json:"headers"`
-	Body      []byte `json:"body"`
-	StartUnix int64  `json:"start_unix"`
-	EndUnix   int64  `json:"end_unix"`
-	Interval  string `json:"interval"`
-	Paused    bool   `json:"paused"`
+	Url               string `json:"url"`
+	Method            string `json:"method"`
+	Namespace         string `json:"namespace"`
+	Params            []byte `json:"params"`
+	Headers           []byte `json:"headers"`
+	Body              []byte `json:"body"`
+	StartUnix         int64  `json:"start_unix"`
+	EndUnix           int64  `json:"end_unix"`
+	Interval          string `json:"interval"`
+	Paused            bool   `json:"paused"`
+	Cron              string `json:"cron"`
+	Timezone          string `json:"timezone"`
+	Retry             []byte `json:"retry"`
+	Timeout           string `json:"timeout"`
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	MisfirePolicy     string `json:"misfire_policy"`
+	MaxRuns           int64  `json:"max_runs"`
+	Auth              []byte `json:"auth"`
+	Signing           []byte `json:"signing"`
+	TlsProfile        string `json:"tls_profile"`
+	SecretFields      []byte `json:"secret_fields"`
 }
 
 func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (int64, error) {
@@ -43,6 +113,17 @@ func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (int64,
 		arg.EndUnix,
 		arg.Interval,
 		arg.Paused,
+		arg.Cron,
+		arg.Timezone,
+		arg.Retry,
+		arg.Timeout,
+		arg.ConcurrencyPolicy,
+		arg.MisfirePolicy,
+		arg.MaxRuns,
+		arg.Auth,
+		arg.Signing,
+		arg.TlsProfile,
+		arg.SecretFields,
 	)
 	var _id int64
 	err := row.Scan(&_id)
@@ -59,9 +140,34 @@ func (q *Queries) DeleteTask(ctx context.Context, ID int64) error {
 	return err
 }
 
+const getActiveTaskIDs = `-- name: GetActiveTaskIDs :many
+SELECT _id FROM tasks
+WHERE (end_unix >= $1 OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed
+`
+
+func (q *Queries) GetActiveTaskIDs(ctx context.Context, endUnix int64) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getActiveTaskIDs, endUnix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []int64{}
+	for rows.Next() {
+		var _id int64
+		if err := rows.Scan(&_id); err != nil {
+			return nil, err
+		}
+		items = append(items, _id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getActiveTasks = `-- name: GetActiveTasks :many
-SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused FROM tasks
-WHERE end_unix >= $1 AND NOT paused
+SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, completed, max_runs, run_count, last_fire_unix, auth, signing, tls_profile, secret_fields FROM tasks
+WHERE (end_unix >= $1 OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed
 `
 
 func (q *Queries) GetActiveTasks(ctx context.Context, endUnix int64) ([]*Task, error) {
@@ -85,6 +191,134 @@ func (q *Queries) GetActiveTasks(ctx context.Context, endUnix int64) ([]*Task, e
 			&i.EndUnix,
 			&i.Interval,
 			&i.Paused,
+			&i.Cron,
+			&i.Timezone,
+			&i.Retry,
+			&i.Timeout,
+			&i.ConcurrencyPolicy,
+			&i.MisfirePolicy,
+			&i.Completed,
+			&i.MaxRuns,
+			&i.RunCount,
+			&i.LastFireUnix,
+			&i.Auth,
+			&i.Signing,
+			&i.TlsProfile,
+			&i.SecretFields,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getActiveTasksByIDs = `-- name: GetActiveTasksByIDs :many
+SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, completed, max_runs, run_count, last_fire_unix, auth, signing, tls_profile, secret_fields FROM tasks
+WHERE (end_unix >= $1 OR (interval = '' AND cron = '')) AND NOT paused AND NOT completed
+  AND _id = ANY($2::bigint[])
+`
+
+type GetActiveTasksByIDsParams struct {
+	EndUnix int64
+	Ids     []int64
+}
+
+func (q *Queries) GetActiveTasksByIDs(ctx context.Context, arg GetActiveTasksByIDsParams) ([]*Task, error) {
+	rows, err := q.db.Query(ctx, getActiveTasksByIDs, arg.EndUnix, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Task{}
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			&i.Method,
+			&i.Namespace,
+			&i.Params,
+			&i.Headers,
+			&i.Body,
+			&i.StartUnix,
+			&i.EndUnix,
+			&i.Interval,
+			&i.Paused,
+			&i.Cron,
+			&i.Timezone,
+			&i.Retry,
+			&i.Timeout,
+			&i.ConcurrencyPolicy,
+			&i.MisfirePolicy,
+			&i.Completed,
+			&i.MaxRuns,
+			&i.RunCount,
+			&i.LastFireUnix,
+			&i.Auth,
+			&i.Signing,
+			&i.TlsProfile,
+			&i.SecretFields,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExecutionsByTask = `-- name: GetExecutionsByTask :many
+SELECT _id, task_id, status, scheduled_at, started_at, ended_at, attempt, status_code, latency_ms, response_body, error, attempts, trigger FROM executions
+WHERE task_id = $1
+  AND scheduled_at >= $2
+  AND scheduled_at < $3
+ORDER BY scheduled_at DESC
+LIMIT $4 OFFSET $5
+`
+
+type GetExecutionsByTaskParams struct {
+	TaskID    int64              `json:"task_id"`
+	Since     pgtype.Timestamptz `json:"since"`
+	Until     pgtype.Timestamptz `json:"until"`
+	RowLimit  int32              `json:"row_limit"`
+	RowOffset int32              `json:"row_offset"`
+}
+
+func (q *Queries) GetExecutionsByTask(ctx context.Context, arg GetExecutionsByTaskParams) ([]*Execution, error) {
+	rows, err := q.db.Query(ctx, getExecutionsByTask,
+		arg.TaskID,
+		arg.Since,
+		arg.Until,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*Execution{}
+	for rows.Next() {
+		var i Execution
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Status,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.Attempt,
+			&i.StatusCode,
+			&i.LatencyMs,
+			&i.ResponseBody,
+			&i.Error,
+			&i.Attempts,
+			&i.Trigger,
 		); err != nil {
 			return nil, err
 		}
@@ -97,7 +331,7 @@ func (q *Queries) GetActiveTasks(ctx context.Context, endUnix int64) ([]*Task, e
 }
 
 const getTaskByID = `-- name: GetTaskByID :one
-SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused FROM tasks
+SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, completed, max_runs, run_count, last_fire_unix, auth, signing, tls_profile, secret_fields FROM tasks
 WHERE _id = $1
 `
 
@@ -116,12 +350,26 @@ func (q *Queries) GetTaskByID(ctx context.Context, ID int64) (*Task, error) {
 		&i.EndUnix,
 		&i.Interval,
 		&i.Paused,
+		&i.Cron,
+		&i.Timezone,
+		&i.Retry,
+		&i.Timeout,
+		&i.ConcurrencyPolicy,
+		&i.MisfirePolicy,
+		&i.Completed,
+		&i.MaxRuns,
+		&i.RunCount,
+		&i.LastFireUnix,
+		&i.Auth,
+		&i.Signing,
+		&i.TlsProfile,
+		&i.SecretFields,
 	)
 	return &i, err
 }
 
 const getTasks = `-- name: GetTasks :many
-SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused FROM tasks
+SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, completed, max_runs, run_count, last_fire_unix, auth, signing, tls_profile, secret_fields FROM tasks
 `
 
 func (q *Queries) GetTasks(ctx context.Context) ([]*Task, error) {
@@ -145,6 +393,20 @@ func (q *Queries) GetTasks(ctx context.Context) ([]*Task, error) {
 			&i.EndUnix,
 			&i.Interval,
 			&i.Paused,
+			&i.Cron,
+			&i.Timezone,
+			&i.Retry,
+			&i.Timeout,
+			&i.ConcurrencyPolicy,
+			&i.MisfirePolicy,
+			&i.Completed,
+			&i.MaxRuns,
+			&i.RunCount,
+			&i.LastFireUnix,
+			&i.Auth,
+			&i.Signing,
+			&i.TlsProfile,
+			&i.SecretFields,
 		); err != nil {
 			return nil, err
 		}
@@ -157,7 +419,7 @@ func (q *Queries) GetTasks(ctx context.Context) ([]*Task, error) {
 }
 
 const getTasksByNamespace = `-- name: GetTasksByNamespace :many
-SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused FROM tasks
+SELECT _id, url, method, namespace, params, headers, body, start_unix, end_unix, interval, paused, cron, timezone, retry, timeout, concurrency_policy, misfire_policy, completed, max_runs, run_count, last_fire_unix, auth, signing, tls_profile, secret_fields FROM tasks
 WHERE namespace = $1
 `
 
@@ -182,6 +444,20 @@ func (q *Queries) GetTasksByNamespace(ctx context.Context, namespace string) ([]
 			&i.EndUnix,
 			&i.Interval,
 			&i.Paused,
+			&i.Cron,
+			&i.Timezone,
+			&i.Retry,
+			&i.Timeout,
+			&i.ConcurrencyPolicy,
+			&i.MisfirePolicy,
+			&i.Completed,
+			&i.MaxRuns,
+			&i.RunCount,
+			&i.LastFireUnix,
+			&i.Auth,
+			&i.Signing,
+			&i.TlsProfile,
+			&i.SecretFields,
 		); err != nil {
 			return nil, err
 		}
@@ -193,6 +469,174 @@ func (q *Queries) GetTasksByNamespace(ctx context.Context, namespace string) ([]
 	return items, nil
 }
 
+const incrementRunCount = `-- name: IncrementRunCount :one
+UPDATE tasks
+SET run_count = run_count + 1
+WHERE _id = $1
+RETURNING run_count
+`
+
+func (q *Queries) IncrementRunCount(ctx context.Context, ID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, incrementRunCount, ID)
+	var run_count int64
+	err := row.Scan(&run_count)
+	return run_count, err
+}
+
+const updateExecution = `-- name: UpdateExecution :exec
+UPDATE executions
+SET status = $2, ended_at = $3, attempt = $4, status_code = $5, latency_ms = $6, response_body = $7,
+  error = $8, attempts = $9
+WHERE _id = $1
+`
+
+type UpdateExecutionParams struct {
+	ID           int64              `json:"_id"`
+	Status       string             `json:"status"`
+	EndedAt      pgtype.Timestamptz `json:"ended_at"`
+	Attempt      int32              `json:"attempt"`
+	StatusCode   int32              `json:"status_code"`
+	LatencyMs    int64              `json:"latency_ms"`
+	ResponseBody string             `json:"response_body"`
+	Error        string             `json:"error"`
+	Attempts     []byte             `json:"attempts"`
+}
+
+func (q *Queries) UpdateExecution(ctx context.Context, arg UpdateExecutionParams) error {
+	_, err := q.db.Exec(ctx, updateExecution,
+		arg.ID,
+		arg.Status,
+		arg.EndedAt,
+		arg.Attempt,
+		arg.StatusCode,
+		arg.LatencyMs,
+		arg.ResponseBody,
+		arg.Error,
+		arg.Attempts,
+	)
+	return err
+}
+
+const updateLastFire = `-- name: UpdateLastFire :exec
+UPDATE tasks
+SET last_fire_unix = GREATEST(last_fire_unix, $1::bigint)
+WHERE _id = $2
+`
+
+type UpdateLastFireParams struct {
+	LastFireUnix int64 `json:"last_fire_unix"`
+	ID           int64 `json:"id"`
+}
+
+func (q *Queries) UpdateLastFire(ctx context.Context, arg UpdateLastFireParams) error {
+	_, err := q.db.Exec(ctx, updateLastFire, arg.LastFireUnix, arg.ID)
+	return err
+}
+
+const updateTask = `-- name: UpdateTask :exec
+UPDATE tasks
+SET url = $2, method = $3, namespace = $4, params = $5, headers = $6, body = $7,
+  start_unix = $8, end_unix = $9, interval = $10, paused = $11, cron = $12, timezone = $13,
+  retry = $14, timeout = $15, concurrency_policy = $16, misfire_policy = $17, max_runs = $18,
+  auth = $19, signing = $20, tls_profile = $21, secret_fields = $22, completed = $23
+WHERE _id = $1
+`
+
+type UpdateTaskParams struct {
+	ID                int64  `json:"_id"`
+	Url               string `json:"url"`
+	Method            string `json:"method"`
+	Namespace         string `json:"namespace"`
+	Params            []byte `json:"params"`
+	Headers           []byte `json:"headers"`
+	Body              []byte `json:"body"`
+	StartUnix         int64  `json:"start_unix"`
+	EndUnix           int64  `json:"end_unix"`
+	Interval          string `json:"interval"`
+	Paused            bool   `json:"paused"`
+	Cron              string `json:"cron"`
+	Timezone          string `json:"timezone"`
+	Retry             []byte `json:"retry"`
+	Timeout           string `json:"timeout"`
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	MisfirePolicy     string `json:"misfire_policy"`
+	MaxRuns           int64  `json:"max_runs"`
+	Auth              []byte `json:"auth"`
+	Signing           []byte `json:"signing"`
+	TlsProfile        string `json:"tls_profile"`
+	SecretFields      []byte `json:"secret_fields"`
+	Completed         bool   `json:"completed"`
+}
+
+func (q *Queries) UpdateTask(ctx context.Context, arg UpdateTaskParams) error {
+	_, err := q.db.Exec(ctx, updateTask,
+		arg.ID,
+		arg.Url,
+		arg.Method,
+		arg.Namespace,
+		arg.Params,
+		arg.Headers,
+		arg.Body,
+		arg.StartUnix,
+		arg.EndUnix,
+		arg.Interval,
+		arg.Paused,
+		arg.Cron,
+		arg.Timezone,
+		arg.Retry,
+		arg.Timeout,
+		arg.ConcurrencyPolicy,
+		arg.MisfirePolicy,
+		arg.MaxRuns,
+		arg.Auth,
+		arg.Signing,
+		arg.TlsProfile,
+		arg.SecretFields,
+		arg.Completed,
+	)
+	return err
+}
+
+const updateTaskSecrets = `-- name: UpdateTaskSecrets :execrows
+UPDATE tasks
+SET headers = $1, body = $2, auth = $3, signing = $4
+WHERE _id = $5
+  AND headers::text IS NOT DISTINCT FROM $6::text
+  AND body::text IS NOT DISTINCT FROM $7::text
+  AND auth::text IS NOT DISTINCT FROM $8::text
+  AND signing::text IS NOT DISTINCT FROM $9::text
+`
+
+type UpdateTaskSecretsParams struct {
+	Headers    []byte  `json:"headers"`
+	Body       []byte  `json:"body"`
+	Auth       []byte  `json:"auth"`
+	Signing    []byte  `json:"signing"`
+	ID         int64   `json:"id"`
+	OldHeaders *string `json:"old_headers"`
+	OldBody    *string `json:"old_body"`
+	OldAuth    *string `json:"old_auth"`
+	OldSigning *string `json:"old_signing"`
+}
+
+func (q *Queries) UpdateTaskSecrets(ctx context.Context, arg UpdateTaskSecretsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateTaskSecrets,
+		arg.Headers,
+		arg.Body,
+		arg.Auth,
+		arg.Signing,
+		arg.ID,
+		arg.OldHeaders,
+		arg.OldBody,
+		arg.OldAuth,
+		arg.OldSigning,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateTaskStatus = `-- name: UpdateTaskStatus :exec
 UPDATE tasks
 SET paused = $2
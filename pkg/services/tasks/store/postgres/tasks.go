@@ -3,26 +3,32 @@ package postgres
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"strconv"
 
+	errors "github.com/maacarma/scheduler/pkg/errors"
 	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
 	sqlgen "github.com/maacarma/scheduler/pkg/services/tasks/store/postgres/sqlgen"
 	utils "github.com/maacarma/scheduler/utils"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // repo is the concrete implementation of the Tasks Repo interface.
 // It holds the required querier instance, which wraps the sqlgen methods.
+// The secrets of the tasks are encrypted by the cipher before they are stored
+// and decrypted once read, so that the callers only see them in plain.
 type repo struct {
 	querier sqlgen.Querier
+	cipher  models.Cipher
 }
 
 // New returns a new instance of the postgres repo.
-func New(pgConn *pgx.Conn) *repo {
+func New(pgConn *pgxpool.Pool, cipher models.Cipher) *repo {
 	querier := sqlgen.New(pgConn)
-	return &repo{querier: querier}
+	return &repo{querier: querier, cipher: cipher}
 }
 
 // GetAll returns all tasks from the database.
@@ -34,7 +40,7 @@ func (r *repo) GetAll(ctx context.Context) ([]*models.Task, error) {
 
 	result := make([]*models.Task, 0)
 	for _, task := range tasks {
-		t, err := convert(task)
+		t, err := convert(task, r.cipher)
 		if err != nil {
 			return nil, err
 		}
@@ -54,7 +60,7 @@ func (r *repo) GetByNamespace(ctx context.Context, namespace string) ([]*models.
 
 	result := make([]*models.Task, 0)
 	for _, task := range tasks {
-		t, err := convert(task)
+		t, err := convert(task, r.cipher)
 		if err != nil {
 			return nil, err
 		}
@@ -68,12 +74,19 @@ func (r *repo) GetByNamespace(ctx context.Context, namespace string) ([]*models.
 // GetByID returns a task from the database with the given id.
 func (r *repo) GetByID(ctx context.Context, idStr string) (*models.Task, error) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, errors.ErrTaskNotFound
+	}
+
 	task, err := r.querier.GetTaskByID(ctx, id)
+	if stderrors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.ErrTaskNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	t, err := convert(task)
+	t, err := convert(task, r.cipher)
 	if err != nil {
 		return nil, err
 	}
@@ -81,59 +94,146 @@ func (r *repo) GetByID(ctx context.Context, idStr string) (*models.Task, error)
 	return t, nil
 }
 
-func (r *repo) GetActiveTasks(ctx context.Context, curUnix utils.Unix) ([]*models.Task, error) {
-	tasks, err := r.querier.GetActiveTasks(ctx, int64(curUnix))
+// GetActiveTasks returns the unexpired, unpaused and uncompleted tasks of the shard.
+// the shard is a consistent hash ring of the task ids, which sql can't evaluate, so the ids of
+// the active tasks are filtered first and only the rows of the shard are read, decoded and decrypted.
+// the tasks which can't be decoded or decrypted are skipped, reported by a models.SkippedTasksError.
+func (r *repo) GetActiveTasks(ctx context.Context, curUnix utils.Unix, shard models.Shard) ([]*models.Task, error) {
+	var tasks []*sqlgen.Task
+	var err error
+	if shard == nil {
+		tasks, err = r.querier.GetActiveTasks(ctx, int64(curUnix))
+	} else {
+		tasks, err = r.getActiveTasksOf(ctx, curUnix, shard)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	result := make([]*models.Task, 0)
+	var skipped []error
 	for _, task := range tasks {
-		t, err := convert(task)
+		t, err := convert(task, r.cipher)
 		if err != nil {
-			return nil, err
+			skipped = append(skipped, err)
+			continue
 		}
 
 		result = append(result, t)
 	}
 
+	if len(skipped) > 0 {
+		return result, &models.SkippedTasksError{Errs: skipped}
+	}
 	return result, nil
 }
 
-// CreateOne creates a new task and returns the id.
-func (r *repo) CreateOne(ctx context.Context, task *models.TaskPayload) (string, error) {
-	paramsInBytes, err := json.Marshal(task.Params)
+// getActiveTasksOf returns the rows of the active tasks owned by the shard.
+func (r *repo) getActiveTasksOf(ctx context.Context, curUnix utils.Unix, shard models.Shard) ([]*sqlgen.Task, error) {
+	ids, err := r.querier.GetActiveTaskIDs(ctx, int64(curUnix))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	headersInBytes, err := json.Marshal(task.Headers)
+	owned := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if shard.Owns(fmt.Sprint(id)) {
+			owned = append(owned, id)
+		}
+	}
+	if len(owned) == 0 {
+		return nil, nil
+	}
+
+	return r.querier.GetActiveTasksByIDs(ctx, sqlgen.GetActiveTasksByIDsParams{EndUnix: int64(curUnix), Ids: owned})
+}
+
+// CreateOne creates a new task and returns the id.
+func (r *repo) CreateOne(ctx context.Context, task *models.TaskPayload) (string, error) {
+	task, err := task.EncryptSecrets(r.cipher)
 	if err != nil {
 		return "", err
 	}
 
-	bodyInBytes, err := json.Marshal(task.Body)
+	fields, err := marshalJSONFields(task)
 	if err != nil {
 		return "", err
 	}
 
 	m := sqlgen.CreateTaskParams{
-		Url:       task.Url,
-		Method:    task.Method,
-		Namespace: task.Namespace,
-		Params:    paramsInBytes,
-		Headers:   headersInBytes,
-		Body:      bodyInBytes,
-		StartUnix: task.StartUnix,
-		EndUnix:   task.EndUnix,
-		Interval:  task.Interval,
-		Paused:    task.Paused,
+		Url:               task.Url,
+		Method:            task.Method,
+		Namespace:         task.Namespace,
+		Params:            fields.params,
+		Headers:           fields.headers,
+		Body:              fields.body,
+		StartUnix:         task.StartUnix,
+		EndUnix:           task.EndUnix,
+		Interval:          task.Interval,
+		Paused:            task.Paused,
+		Cron:              task.Cron,
+		Timezone:          task.Timezone,
+		Retry:             fields.retry,
+		Timeout:           task.Timeout,
+		ConcurrencyPolicy: task.ConcurrencyPolicy,
+		MisfirePolicy:     task.MisfirePolicy,
+		MaxRuns:           task.MaxRuns,
+		Auth:              fields.auth,
+		Signing:           fields.signing,
+		TlsProfile:        task.TLSProfile,
+		SecretFields:      fields.secretFields,
 	}
 
 	id, err := r.querier.CreateTask(ctx, m)
 	return fmt.Sprint(id), err
 }
 
+// Update replaces the definition of a task along with its completed state.
+func (r *repo) Update(ctx context.Context, idStr string, task *models.TaskPayload, completed bool) error {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	task, err = task.EncryptSecrets(r.cipher)
+	if err != nil {
+		return err
+	}
+
+	fields, err := marshalJSONFields(task)
+	if err != nil {
+		return err
+	}
+
+	args := sqlgen.UpdateTaskParams{
+		ID:                id,
+		Url:               task.Url,
+		Method:            task.Method,
+		Namespace:         task.Namespace,
+		Params:            fields.params,
+		Headers:           fields.headers,
+		Body:              fields.body,
+		StartUnix:         task.StartUnix,
+		EndUnix:           task.EndUnix,
+		Interval:          task.Interval,
+		Paused:            task.Paused,
+		Cron:              task.Cron,
+		Timezone:          task.Timezone,
+		Retry:             fields.retry,
+		Timeout:           task.Timeout,
+		ConcurrencyPolicy: task.ConcurrencyPolicy,
+		MisfirePolicy:     task.MisfirePolicy,
+		MaxRuns:           task.MaxRuns,
+		Auth:              fields.auth,
+		Signing:           fields.signing,
+		TlsProfile:        task.TLSProfile,
+		SecretFields:      fields.secretFields,
+		Completed:         completed,
+	}
+
+	return r.querier.UpdateTask(ctx, args)
+}
+
 // UpdateStatus updates the paused status of a task.
 func (r *repo) UpdateStatus(ctx context.Context, idStr string, paused bool) error {
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -145,6 +245,37 @@ func (r *repo) UpdateStatus(ctx context.Context, idStr string, paused bool) erro
 	return r.querier.UpdateTaskStatus(ctx, args)
 }
 
+// Complete marks a task as completed, a completed task is no longer scheduled.
+func (r *repo) Complete(ctx context.Context, idStr string) error {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return r.querier.CompleteTask(ctx, id)
+}
+
+// IncrementRunCount atomically increments the run count of a task and returns it.
+func (r *repo) IncrementRunCount(ctx context.Context, idStr string) (int64, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.querier.IncrementRunCount(ctx, id)
+}
+
+// UpdateLastFire updates the last scheduled fire time of a task, it never moves backwards.
+func (r *repo) UpdateLastFire(ctx context.Context, idStr string, fireUnix int64) error {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	args := sqlgen.UpdateLastFireParams{ID: id, LastFireUnix: fireUnix}
+	return r.querier.UpdateLastFire(ctx, args)
+}
+
 // DeleteTask deletes a task
 func (r *repo) Delete(ctx context.Context, idStr string) error {
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -155,8 +286,132 @@ func (r *repo) Delete(ctx context.Context, idStr string) error {
 	return r.querier.DeleteTask(ctx, id)
 }
 
-// convert converts a sqlgen task to a native task model.
-func convert(task *sqlgen.Task) (*models.Task, error) {
+// RotateSecrets re-encrypts the secrets of the tasks which aren't encrypted with the current key
+// of the cipher, including the secrets stored in plain. It returns the count of the tasks re-encrypted.
+// A task updated meanwhile is left as is, its update encrypts it with the current key anyway.
+func (r *repo) RotateSecrets(ctx context.Context) (int, error) {
+	tasks, err := r.querier.GetTasks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, task := range tasks {
+		t, err := decode(task)
+		if err != nil {
+			return rotated, err
+		}
+		if t.SecretsCurrent(r.cipher) {
+			continue
+		}
+
+		if err := t.DecryptSecrets(r.cipher); err != nil {
+			return rotated, err
+		}
+		payload := t.ConvertToPayload()
+		encrypted, err := payload.EncryptSecrets(r.cipher)
+		if err != nil {
+			return rotated, err
+		}
+		fields, err := marshalJSONFields(encrypted)
+		if err != nil {
+			return rotated, err
+		}
+
+		// the secrets are replaced only if they are still those read above
+		args := sqlgen.UpdateTaskSecretsParams{
+			Headers:    fields.headers,
+			Body:       fields.body,
+			Auth:       fields.auth,
+			Signing:    fields.signing,
+			ID:         task.ID,
+			OldHeaders: nullableText(task.Headers),
+			OldBody:    nullableText(task.Body),
+			OldAuth:    nullableText(task.Auth),
+			OldSigning: nullableText(task.Signing),
+		}
+		count, err := r.querier.UpdateTaskSecrets(ctx, args)
+		if err != nil {
+			return rotated, err
+		}
+		rotated += int(count)
+	}
+
+	return rotated, nil
+}
+
+// nullableText returns the text of a nullable json column.
+func nullableText(column []byte) *string {
+	if column == nil {
+		return nil
+	}
+
+	text := string(column)
+	return &text
+}
+
+// jsonFields holds the task fields stored as json columns.
+type jsonFields struct {
+	params       []byte
+	headers      []byte
+	body         []byte
+	retry        []byte
+	auth         []byte
+	signing      []byte
+	secretFields []byte
+}
+
+// marshalJSONFields marshals the task fields stored as json columns.
+func marshalJSONFields(task *models.TaskPayload) (*jsonFields, error) {
+	var fields jsonFields
+	var err error
+	if fields.params, err = json.Marshal(task.Params); err != nil {
+		return nil, err
+	}
+
+	if fields.headers, err = json.Marshal(task.Headers); err != nil {
+		return nil, err
+	}
+
+	if fields.body, err = json.Marshal(task.Body); err != nil {
+		return nil, err
+	}
+
+	if fields.retry, err = json.Marshal(task.Retry); err != nil {
+		return nil, err
+	}
+
+	if fields.auth, err = json.Marshal(task.Auth); err != nil {
+		return nil, err
+	}
+
+	if fields.signing, err = json.Marshal(task.Signing); err != nil {
+		return nil, err
+	}
+
+	if fields.secretFields, err = json.Marshal(task.SecretFields); err != nil {
+		return nil, err
+	}
+
+	return &fields, nil
+}
+
+// convert converts a sqlgen task to a native task model with its secrets decrypted by the cipher.
+func convert(task *sqlgen.Task, cipher models.Cipher) (*models.Task, error) {
+	t, err := decode(task)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.DecryptSecrets(cipher); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// decode converts a sqlgen task to a native task model, the secrets are left as stored.
+func decode(task *sqlgen.Task) (*models.Task, error) {
 	var t models.Task
 	err := json.Unmarshal(task.Params, &t.Params)
 	if err != nil {
@@ -173,6 +428,36 @@ func convert(task *sqlgen.Task) (*models.Task, error) {
 		return nil, err
 	}
 
+	// retry is a nullable column, tasks without a retry policy stores null
+	if len(task.Retry) > 0 {
+		err = json.Unmarshal(task.Retry, &t.Retry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// auth, signing and secret_fields are nullable columns as well
+	if len(task.Auth) > 0 {
+		err = json.Unmarshal(task.Auth, &t.Auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(task.Signing) > 0 {
+		err = json.Unmarshal(task.Signing, &t.Signing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(task.SecretFields) > 0 {
+		err = json.Unmarshal(task.SecretFields, &t.SecretFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	t.ID = fmt.Sprint(task.ID)
 	t.Url = task.Url
 	t.Method = task.Method
@@ -181,6 +466,16 @@ func convert(task *sqlgen.Task) (*models.Task, error) {
 	t.EndUnix = task.EndUnix
 	t.Interval = task.Interval
 	t.Paused = task.Paused
+	t.Cron = task.Cron
+	t.Timezone = task.Timezone
+	t.Timeout = task.Timeout
+	t.ConcurrencyPolicy = task.ConcurrencyPolicy
+	t.MisfirePolicy = task.MisfirePolicy
+	t.Completed = task.Completed
+	t.MaxRuns = task.MaxRuns
+	t.RunCount = task.RunCount
+	t.LastFireUnix = task.LastFireUnix
+	t.TLSProfile = task.TlsProfile
 
 	return &t, nil
 }
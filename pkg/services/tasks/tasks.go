@@ -1,16 +1,16 @@
 package tasks
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"net/url"
+	"time"
 
+	config "github.com/maacarma/scheduler/config"
+	vErrors "github.com/maacarma/scheduler/pkg/errors"
 	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
 	utils "github.com/maacarma/scheduler/utils"
-
-	"go.uber.org/zap"
 )
 
 // Repo is the interface that wraps the required repository methods.
@@ -21,61 +21,98 @@ type Repo interface {
 	GetByNamespace(ctx context.Context, namespace string) ([]*models.Task, error)
 	CreateOne(ctx context.Context, task *models.TaskPayload) (string, error)
 	UpdateStatus(ctx context.Context, id string, paused bool) error
+	Update(ctx context.Context, id string, task *models.TaskPayload, completed bool) error
 	Delete(ctx context.Context, id string) error
 }
 
+// ExecutionRepo is the interface that wraps the execution history repository methods.
+type ExecutionRepo interface {
+	CreateExecution(ctx context.Context, execution *models.Execution) (string, error)
+	UpdateExecution(ctx context.Context, execution *models.Execution) error
+	GetExecutions(ctx context.Context, taskID string, filter models.ExecutionFilter) ([]*models.Execution, error)
+}
+
 // Scheduler is the interface that wraps the scheduler methods.
 type Scheduler interface {
 	ScheduleTask(task *models.Task)
+	RescheduleTask(task *models.Task)
 	DiscardTaskNow(id string)
+	TaskState(id string) models.TaskState
+	TriggerTask(ctx context.Context, task *models.Task, sync bool) *models.Execution
 }
 
 // Service is the interface that wraps tasks service methods.
 type Service interface {
 	GetAll(ctx context.Context) ([]*models.Task, error)
+	GetByID(ctx context.Context, id string) (*models.TaskDetails, error)
 	GetByNamespace(ctx context.Context, namespace string) ([]*models.Task, error)
+	NextRuns(ctx context.Context, id string, count int) ([]time.Time, error)
 	Create(ctx context.Context, task *models.TaskPayload) (string, int, error)
 	ToggleStatus(ctx context.Context, id string) error
+	Update(ctx context.Context, id string, patch []byte) (*models.Task, int, error)
 	Delete(ctx context.Context, id string) error
-}
-
-type Executor struct {
-	task   *models.Task
-	logger *zap.Logger
-}
-
-func NewExecutor(task *models.Task, logger *zap.Logger) *Executor {
-	return &Executor{task: task, logger: logger}
+	Run(ctx context.Context, id string, override *models.RunOverride, sync bool) (*models.Execution, int, error)
+	GetExecutions(ctx context.Context, id string, filter models.ExecutionFilter) ([]*models.Execution, error)
 }
 
 // tasks is the concrete implementation of the Service interface.
 // It holds the required repository instance.
 type svc struct {
-	repo      Repo
-	scheduler Scheduler
+	repo       Repo
+	executions ExecutionRepo
+	scheduler  Scheduler
+	conf       *config.Config
 }
 
 // New returns a new instance of the tasks service.
-func New(repo Repo, scheduler Scheduler) Service {
+func New(repo Repo, executions ExecutionRepo, scheduler Scheduler, conf *config.Config) Service {
 	return &svc{
-		repo:      repo,
-		scheduler: scheduler,
+		repo:       repo,
+		executions: executions,
+		scheduler:  scheduler,
+		conf:       conf,
 	}
 }
 
+// GetAll returns all the tasks with their secrets redacted.
 func (s *svc) GetAll(ctx context.Context) ([]*models.Task, error) {
-	return s.repo.GetAll(ctx)
+	tasks, err := s.repo.GetAll(ctx)
+	return redact(tasks), err
+}
+
+// GetByID returns the task along with its runtime state in the scheduler,
+// the secrets of the task are redacted.
+func (s *svc) GetByID(ctx context.Context, id string) (*models.TaskDetails, error) {
+	task, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TaskDetails{Task: task.Redact(), State: s.scheduler.TaskState(id)}, nil
+}
+
+// NextRuns returns the next count fire times of the task from now.
+func (s *svc) NextRuns(ctx context.Context, id string, count int) ([]time.Time, error) {
+	task, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.NextRuns(time.Now().UTC(), count)
 }
 
+// GetByNamespace returns the tasks of the namespace with their secrets redacted.
 func (s *svc) GetByNamespace(ctx context.Context, namespace string) ([]*models.Task, error) {
-	return s.repo.GetByNamespace(ctx, namespace)
+	tasks, err := s.repo.GetByNamespace(ctx, namespace)
+	return redact(tasks), err
 }
 
 func (s *svc) Create(ctx context.Context, task *models.TaskPayload) (string, int, error) {
-	if task.Namespace == "" {
-		task.Namespace = "default"
+	if verr := s.validateTLSProfile(task); verr != nil {
+		return "", http.StatusBadRequest, verr
 	}
 
+	setDefaults(task)
 	id, err := s.repo.CreateOne(ctx, task)
 	if err != nil {
 		return "", http.StatusInternalServerError, err
@@ -110,39 +147,139 @@ func (s *svc) ToggleStatus(ctx context.Context, id string) error {
 	return nil
 }
 
+// Update applies the JSON merge patch (RFC 7386) to the task,
+// re-validates it and reschedules the task with its new definition.
+// The secrets left redacted in the patch keep their values, the updated task is returned redacted.
+func (s *svc) Update(ctx context.Context, id string, patch []byte) (*models.Task, int, error) {
+	task, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, vErrors.ErrTaskNotFound) {
+		return nil, http.StatusNotFound, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	doc, err := json.Marshal(task.ConvertToPayload())
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	patched, err := utils.MergePatch(doc, patch)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var payload models.TaskPayload
+	if err := json.Unmarshal(patched, &payload); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	payload.RestoreSecrets(task)
+	setDefaults(&payload)
+	if verr := payload.ValidateUpdate(task); verr != nil {
+		return nil, http.StatusBadRequest, verr
+	}
+	if verr := s.validateTLSProfile(&payload); verr != nil {
+		return nil, http.StatusBadRequest, verr
+	}
+
+	completed := payload.CompletedAfterUpdate(task)
+	if err := s.repo.Update(ctx, id, &payload, completed); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	tModel := payload.ConvertToTask(id)
+	tModel.RunCount = task.RunCount
+	tModel.Completed = completed
+	s.scheduler.RescheduleTask(&tModel)
+
+	return tModel.Redact(), http.StatusOK, nil
+}
+
 func (s *svc) Delete(ctx context.Context, id string) error {
 	s.scheduler.DiscardTaskNow(id)
 	return s.repo.Delete(ctx, id)
 }
 
-// ExecuteTask executes a task
-// This method is used by the cron to execute tasks.
-func (s *Executor) Run() {
-	s.logger.Info("executing: ", zap.String("task_id", s.task.ID))
-
-	url, err := url.Parse(s.task.Url)
+// Run executes the task right away as a manual execution, without touching its schedule.
+// The override replaces the body and params of the task for this run only.
+// The execution is returned once started, or once completed if sync is set.
+func (s *svc) Run(ctx context.Context, id string, override *models.RunOverride, sync bool) (*models.Execution, int, error) {
+	task, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, vErrors.ErrTaskNotFound) {
+		return nil, http.StatusNotFound, err
+	}
 	if err != nil {
-		s.logger.Error("failed to parse url", zap.Error(err))
-		return
+		return nil, http.StatusInternalServerError, err
 	}
-	utils.AppendQueryParams(url, s.task.Params)
 
-	bodyBytes, err := json.Marshal(s.task.Body)
-	if err != nil {
-		s.logger.Error("failed to marshal body", zap.Error(err))
-		return
+	if override != nil {
+		task = override.Apply(task)
 	}
 
-	req, _ := http.NewRequest(s.task.Method, url.String(), bytes.NewBuffer(bodyBytes))
-	req.Header = s.task.Headers
+	execution := s.scheduler.TriggerTask(ctx, task, sync)
+	if execution == nil {
+		return nil, http.StatusServiceUnavailable, errors.New("the scheduler is shutting down")
+	}
+	if execution.ID == "" {
+		return nil, http.StatusInternalServerError, errors.New("failed to record the execution")
+	}
+	if sync {
+		return execution, http.StatusOK, nil
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		s.logger.Error("failed to execute task", zap.Error(err))
-		return
+	return execution, http.StatusAccepted, nil
+}
+
+func (s *svc) GetExecutions(ctx context.Context, id string, filter models.ExecutionFilter) ([]*models.Execution, error) {
+	return s.executions.GetExecutions(ctx, id, filter)
+}
+
+// validateTLSProfile checks if the tls profile of the task is in the config.
+func (s *svc) validateTLSProfile(task *models.TaskPayload) *vErrors.Validation {
+	if task.TLSProfile == "" {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	s.logger.Info("task executed", zap.String("task_id", s.task.ID), zap.Int("status_code", resp.StatusCode))
+	if _, ok := s.conf.TLSProfile(task.TLSProfile); !ok {
+		return vErrors.InvalidPayload("tls_profile", vErrors.InvalidFieldMsg, "unknown tls profile "+task.TLSProfile)
+	}
+	return nil
+}
+
+// redact redacts the secrets of the tasks for the api responses.
+func redact(tasks []*models.Task) []*models.Task {
+	redacted := make([]*models.Task, len(tasks))
+	for i, task := range tasks {
+		redacted[i] = task.Redact()
+	}
+	return redacted
+}
+
+// setDefaults sets the defaults of the optional task fields.
+func setDefaults(task *models.TaskPayload) {
+	if task.Namespace == "" {
+		task.Namespace = "default"
+	}
+
+	if task.Timezone == "" {
+		task.Timezone = "UTC"
+	}
+
+	if task.ConcurrencyPolicy == "" {
+		task.ConcurrencyPolicy = models.ConcurrencyAllow
+	}
+
+	// a missed one-shot task still runs by default,
+	// while the missed fire times of a recurring task are left behind
+	if task.MisfirePolicy == "" {
+		task.MisfirePolicy = models.MisfireSkip
+		if task.IsOneShot() {
+			task.MisfirePolicy = models.MisfireFireOnce
+		}
+	}
+
+	if task.IsOneShot() && task.EndUnix == 0 {
+		task.EndUnix = task.StartUnix
+	}
 }
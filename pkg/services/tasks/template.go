@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/maacarma/scheduler/config"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+)
+
+const unsetEnv = "env var %s is not set"
+
+var (
+	errEnvDisabled     = errors.New("env lookups are disabled, templates.env_prefix is not configured")
+	errSecretsDisabled = errors.New("secret lookups are disabled, templates.secrets_dir is not configured")
+)
+
+// templateLookups returns the lookups of the env vars and secrets of the task templates as per the config.
+// The env vars are limited to those with the configured prefix, so that the templates can't read
+// the config of the scheduler itself. The secret files are read on each lookup, so that they can be rotated.
+func templateLookups(conf *config.Config) models.TemplateLookups {
+	return models.TemplateLookups{
+		Env: func(name string) (string, error) {
+			if conf.Templates.EnvPrefix == "" {
+				return "", errEnvDisabled
+			}
+			value, ok := os.LookupEnv(conf.Templates.EnvPrefix + name)
+			if !ok {
+				return "", fmt.Errorf(unsetEnv, conf.Templates.EnvPrefix+name)
+			}
+			return value, nil
+		},
+		Secret: func(name string) (string, error) {
+			if conf.Templates.SecretsDir == "" {
+				return "", errSecretsDisabled
+			}
+			secret, err := os.ReadFile(filepath.Join(conf.Templates.SecretsDir, name))
+			if err != nil {
+				return "", err
+			}
+			// the secret files usually end with a newline
+			return strings.TrimRight(string(secret), "\r\n"), nil
+		},
+	}
+}
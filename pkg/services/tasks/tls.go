@@ -0,0 +1,94 @@
+package tasks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	config "github.com/maacarma/scheduler/config"
+)
+
+const (
+	unknownTLSProfile = "unknown tls profile %s"
+	invalidTLSProfile = "invalid tls profile %s: %w"
+)
+
+// tlsVersions are the tls versions accepted as the min version of a tls profile.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultClient is the http client of the task's requests without a tls profile.
+var defaultClient = &http.Client{}
+
+// transports caches the http transports of the tls profiles by the profile name,
+// so that the connections to the endpoints are reused across the runs of the tasks.
+var transports sync.Map
+
+// httpClient returns the http client of the task's requests,
+// the client uses the transport of the task's tls profile if any.
+func httpClient(conf *config.Config, profile string) (*http.Client, error) {
+	if profile == "" {
+		return defaultClient, nil
+	}
+
+	name := strings.ToLower(profile)
+	if transport, ok := transports.Load(name); ok {
+		return &http.Client{Transport: transport.(*http.Transport)}, nil
+	}
+
+	tlsProfile, ok := conf.TLSProfile(name)
+	if !ok {
+		return nil, fmt.Errorf(unknownTLSProfile, profile)
+	}
+	tlsConf, err := tlsConfig(tlsProfile)
+	if err != nil {
+		return nil, fmt.Errorf(invalidTLSProfile, profile, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	cached, _ := transports.LoadOrStore(name, transport)
+	return &http.Client{Transport: cached.(*http.Transport)}, nil
+}
+
+// tlsConfig builds the tls config of the tls profile.
+func tlsConfig(profile config.TLSProfile) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: profile.ServerName}
+
+	if profile.MinVersion != "" {
+		version, ok := tlsVersions[profile.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min version %s", profile.MinVersion)
+		}
+		conf.MinVersion = version
+	}
+
+	if profile.CAFile != "" {
+		bundle, err := os.ReadFile(profile.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in %s", profile.CAFile)
+		}
+	}
+
+	if profile.CertFile != "" || profile.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(profile.CertFile, profile.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
@@ -1,9 +1,18 @@
 package transport
 
 import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	config "github.com/maacarma/scheduler/config"
 	db "github.com/maacarma/scheduler/pkg/db"
+	encryption "github.com/maacarma/scheduler/pkg/encryption"
+	errors "github.com/maacarma/scheduler/pkg/errors"
 	svc "github.com/maacarma/scheduler/pkg/services/tasks"
 	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
 	mongodb "github.com/maacarma/scheduler/pkg/services/tasks/store/mongodb"
@@ -13,16 +22,28 @@ import (
 )
 
 // Activate activates the router.
-func Activate(router *gin.Engine, dbClients *db.Clients, scheduler svc.Scheduler) {
+func Activate(ctx context.Context, router *gin.Engine, dbClients *db.Clients, scheduler svc.Scheduler, conf *config.Config) error {
+	keyring, err := encryption.FromConfig(conf)
+	if err != nil {
+		return err
+	}
+
 	var repo svc.Repo
+	var executions svc.ExecutionRepo
 	switch {
 	case dbClients.Pg != nil:
-		repo = postgres.New(dbClients.Pg)
+		repo = postgres.New(dbClients.Pg, keyring)
+		executions = postgres.NewExecutions(dbClients.Pg)
 	case dbClients.Mongo != nil:
-		repo = mongodb.New(dbClients.Mongo)
+		repo = mongodb.New(dbClients.Mongo, keyring)
+		executions, err = mongodb.NewExecutions(ctx, dbClients.Mongo)
+		if err != nil {
+			return err
+		}
 	}
 
-	newHandler(router, svc.New(repo, scheduler))
+	newHandler(router, svc.New(repo, executions, scheduler, conf))
+	return nil
 }
 
 // handler is the concrete implementation of the tasks http methods.
@@ -36,10 +57,15 @@ func newHandler(router *gin.Engine, sc svc.Service) {
 		service: sc,
 	}
 	router.GET("/tasks", h.GetAll)
+	router.GET("/tasks/:id", h.GetByID)
+	router.GET("/tasks/:id/next-runs", h.NextRuns)
 	router.POST("/tasks", h.CreateTask)
+	router.PATCH("/tasks/:id", h.UpdateTask)
 	router.DELETE("/tasks/:id", h.DeleteTask)
 	router.PUT("/tasks/:id/status", h.ToggleStatus)
+	router.POST("/tasks/:id/run", h.RunTask)
 	router.GET("/tasks/n/:namespace", h.GetAllByNamespace)
+	router.GET("/tasks/:id/executions", h.GetExecutions)
 }
 
 // GetAll returns all tasks
@@ -53,6 +79,47 @@ func (h *handler) GetAll(c *gin.Context) {
 	c.JSON(http.StatusOK, tasks)
 }
 
+// GetByID returns a task along with its runtime state in the scheduler.
+func (h *handler) GetByID(c *gin.Context) {
+	task, err := h.service.GetByID(c.Request.Context(), c.Param("id"))
+	if stderrors.Is(err, errors.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// NextRuns returns the next fire times of a task within its start and end time.
+// Query params: count (optional) defaults to 5 and is capped to 100.
+func (h *handler) NextRuns(c *gin.Context) {
+	count := models.DefaultNextRunsCount
+	if value := c.Query("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, errors.InvalidPayload("count", errors.InvalidFieldMsg, "count should be a positive number"))
+			return
+		}
+		count = min(n, models.MaxNextRunsCount)
+	}
+
+	runs, err := h.service.NextRuns(c.Request.Context(), c.Param("id"), count)
+	if stderrors.Is(err, errors.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"next_runs": runs})
+}
+
 // GetAllByNamespace returns all tasks by namespace
 func (h *handler) GetAllByNamespace(c *gin.Context) {
 	tasks, err := h.service.GetByNamespace(c.Request.Context(), c.Param("namespace"))
@@ -78,6 +145,11 @@ func (h *handler) CreateTask(c *gin.Context) {
 	}
 
 	id, statusCode, err := h.service.Create(c.Request.Context(), &task)
+	var verr *errors.Validation
+	if stderrors.As(err, &verr) {
+		c.JSON(statusCode, verr)
+		return
+	}
 	if err != nil {
 		c.JSON(statusCode, gin.H{"error": err.Error()})
 		return
@@ -98,6 +170,29 @@ func (h *handler) ToggleStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, map[string]bool{"updated": true})
 }
 
+// UpdateTask updates a task with a JSON merge patch (RFC 7386) of the task payload.
+// Ex: {"interval": "1m", "headers": null} changes the interval and removes the headers.
+func (h *handler) UpdateTask(c *gin.Context) {
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, statusCode, err := h.service.Update(c.Request.Context(), c.Param("id"), patch)
+	var verr *errors.Validation
+	if stderrors.As(err, &verr) {
+		c.JSON(statusCode, verr)
+		return
+	}
+	if err != nil {
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
 func (h *handler) DeleteTask(c *gin.Context) {
 	id := c.Param("id")
 	err := h.service.Delete(c.Request.Context(), id)
@@ -108,3 +203,100 @@ func (h *handler) DeleteTask(c *gin.Context) {
 
 	c.JSON(http.StatusOK, map[string]bool{"deleted": true})
 }
+
+// RunTask runs a task right away without touching its schedule.
+// The optional body {"body": {...}, "params": {...}} overrides the task's body and params for this run.
+// Query params: sync (optional) waits for the execution to complete and returns it,
+// otherwise the id of the started execution is returned.
+func (h *handler) RunTask(c *gin.Context) {
+	// a chunked request has an unknown length, so the body is read whenever one may be present
+	// and an empty one leaves the task as it is.
+	var override *models.RunOverride
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		override = &models.RunOverride{}
+		if err := c.ShouldBindJSON(override); err != nil {
+			if !stderrors.Is(err, io.EOF) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			override = nil
+		}
+	}
+
+	sync, err := strconv.ParseBool(c.DefaultQuery("sync", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.InvalidPayload("sync", errors.InvalidFieldMsg, err.Error()))
+		return
+	}
+
+	execution, statusCode, err := h.service.Run(c.Request.Context(), c.Param("id"), override, sync)
+	if err != nil {
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	if sync {
+		c.JSON(statusCode, execution)
+		return
+	}
+	c.JSON(statusCode, map[string]string{"execution_id": execution.ID})
+}
+
+// GetExecutions returns the execution history of a task, latest first.
+// Query params (all optional):
+// since, until: unix time range of the scheduled time, until is exclusive and defaults to now.
+// limit, offset: pagination, limit defaults to 50 and is capped to 500.
+func (h *handler) GetExecutions(c *gin.Context) {
+	filter, verr := executionFilter(c)
+	if verr != nil {
+		c.JSON(http.StatusBadRequest, verr)
+		return
+	}
+
+	executions, err := h.service.GetExecutions(c.Request.Context(), c.Param("id"), filter)
+	if stderrors.Is(err, errors.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, executions)
+}
+
+// executionFilter parses the execution filter from the query params.
+func executionFilter(c *gin.Context) (models.ExecutionFilter, *errors.Validation) {
+	filter := models.ExecutionFilter{
+		Since: time.Unix(0, 0).UTC(),
+		Until: time.Now().UTC(),
+		Limit: models.DefaultExecutionsLimit,
+	}
+
+	for key, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(key); value != "" {
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return filter, errors.InvalidPayload(key, errors.InvalidFieldMsg, err.Error())
+			}
+			*dst = time.Unix(unix, 0).UTC()
+		}
+	}
+
+	for key, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := c.Query(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err == nil && n < 0 {
+				err = fmt.Errorf("%s should be positive", key)
+			}
+			if err != nil {
+				return filter, errors.InvalidPayload(key, errors.InvalidFieldMsg, err.Error())
+			}
+			*dst = n
+		}
+	}
+	filter.Limit = min(filter.Limit, models.MaxExecutionsLimit)
+
+	return filter, nil
+}
@@ -0,0 +1,199 @@
+// Package signing signs the requests of the scheduler's tasks with HMAC-SHA256,
+// so that the receivers can verify the requests come from the scheduler.
+//
+// A signed request carries the unix time it was signed at in the timestamp header
+// and a signature per active key in the signature header:
+//
+//	X-Scheduler-Timestamp: 1725216780
+//	X-Scheduler-Signature: k2=5d41402a..., k1=7c211433...
+//
+// A signature is the hex encoded HMAC-SHA256 of the timestamp, method, url and body
+// joined by new lines, keyed by the secret of the key. Signing with every active key
+// lets the receivers move to a new key before the old one is retired.
+//
+// The package depends only on the standard library, receivers verify the requests with a Verifier:
+//
+//	verifier := &signing.Verifier{Keys: []signing.Key{{ID: "k1", Secret: os.Getenv("SIGNING_SECRET")}}}
+//	http.Handle("/webhook", verifier.Middleware(handler))
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaults of the headers and the verification
+const (
+	DefaultSignatureHeader = "X-Scheduler-Signature"
+	DefaultTimestampHeader = "X-Scheduler-Timestamp"
+	// DefaultTolerance is how far the timestamp of a request can be from the receiver's clock.
+	DefaultTolerance = 5 * time.Minute
+)
+
+// errors of the verification
+var (
+	ErrMissingSignature = errors.New("signing: missing signature")
+	ErrInvalidTimestamp = errors.New("signing: invalid timestamp")
+	ErrStaleTimestamp   = errors.New("signing: timestamp out of tolerance")
+	ErrInvalidSignature = errors.New("signing: no signature matches the keys")
+)
+
+// Key is a signing secret identified by its id.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Signature returns the hex encoded HMAC-SHA256 of the request keyed by the secret.
+func Signature(secret string, timestamp int64, method, url string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("\n" + method + "\n" + url + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer signs the requests with the keys.
+// Empty header names default to DefaultSignatureHeader and DefaultTimestampHeader.
+type Signer struct {
+	Keys            []Key
+	SignatureHeader string
+	TimestampHeader string
+}
+
+// Sign sets the timestamp and signature headers of the request with the body signed at the given time.
+func (s *Signer) Sign(req *http.Request, body []byte, at time.Time) {
+	timestamp := at.Unix()
+	target := requestURL(req.URL, req.Host)
+
+	signatures := make([]string, 0, len(s.Keys))
+	for _, key := range s.Keys {
+		signatures = append(signatures, key.ID+"="+Signature(key.Secret, timestamp, req.Method, target, body))
+	}
+
+	req.Header.Set(headerOr(s.TimestampHeader, DefaultTimestampHeader), strconv.FormatInt(timestamp, 10))
+	req.Header.Set(headerOr(s.SignatureHeader, DefaultSignatureHeader), strings.Join(signatures, ", "))
+}
+
+// Verifier verifies the signed requests with the keys, a request is verified
+// if any of its signatures matches a key of the same id.
+//
+// Empty header names default to DefaultSignatureHeader and DefaultTimestampHeader,
+// a zero Tolerance defaults to DefaultTolerance.
+//
+// BaseURL is the scheme and host the scheduler calls (Ex: https://api.example.com),
+// needed when the receiver is behind a proxy rewriting them. it defaults to the
+// scheme of the connection (or X-Forwarded-Proto) and the host of the request.
+type Verifier struct {
+	Keys            []Key
+	SignatureHeader string
+	TimestampHeader string
+	Tolerance       time.Duration
+	BaseURL         string
+}
+
+// Verify verifies the signature of the request, the body is read and restored for the handlers.
+func (v *Verifier) Verify(r *http.Request) error {
+	header := r.Header.Get(headerOr(v.SignatureHeader, DefaultSignatureHeader))
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(headerOr(v.TimestampHeader, DefaultTimestampHeader)), 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > tolerance || skew < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	target, err := v.url(r)
+	if err != nil {
+		return err
+	}
+
+	for _, signature := range strings.Split(header, ",") {
+		id, sig, ok := strings.Cut(strings.TrimSpace(signature), "=")
+		if !ok {
+			continue
+		}
+		for _, key := range v.Keys {
+			expected := Signature(key.Secret, timestamp, r.Method, target, body)
+			if key.ID == id && hmac.Equal([]byte(sig), []byte(expected)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// Middleware responds 401 to the requests failing the verification.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// url returns the url of the request as signed by the scheduler.
+func (v *Verifier) url(r *http.Request) (string, error) {
+	if v.BaseURL != "" {
+		base, err := url.Parse(v.BaseURL)
+		if err != nil {
+			return "", err
+		}
+		return base.Scheme + "://" + base.Host + r.URL.RequestURI(), nil
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI(), nil
+}
+
+// requestURL returns the url of a request the way its receiver sees it,
+// the scheme, host and request uri without the user info and fragment.
+func requestURL(u *url.URL, host string) string {
+	if host == "" {
+		host = u.Host
+	}
+	return u.Scheme + "://" + host + u.RequestURI()
+}
+
+func headerOr(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,134 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the known-answer vector, the signature is computed with
+// printf '1725216780\nPOST\nhttps://api.example.com/hooks/billing?source=scheduler\n{"invoice":42}' | openssl dgst -sha256 -hmac whsec_test
+const (
+	vectorSecret    = "whsec_test"
+	vectorTimestamp = 1725216780
+	vectorMethod    = http.MethodPost
+	vectorURL       = "https://api.example.com/hooks/billing?source=scheduler"
+	vectorBody      = `{"invoice":42}`
+	vectorCanonical = "1725216780\nPOST\nhttps://api.example.com/hooks/billing?source=scheduler\n{\"invoice\":42}"
+	vectorSignature = "3280d63052905b47017a215c41ee254e72594e9db6413d6d014d008e8dd0b73c"
+)
+
+func TestSignatureVector(t *testing.T) {
+	// the canonical string is the timestamp, method, url and body joined by new lines
+	mac := hmac.New(sha256.New, []byte(vectorSecret))
+	mac.Write([]byte(vectorCanonical))
+	if got := hex.EncodeToString(mac.Sum(nil)); got != vectorSignature {
+		t.Fatalf("canonical string: got %s, want %s", got, vectorSignature)
+	}
+
+	if got := Signature(vectorSecret, vectorTimestamp, vectorMethod, vectorURL, []byte(vectorBody)); got != vectorSignature {
+		t.Fatalf("got %s, want %s", got, vectorSignature)
+	}
+}
+
+func TestSignerHeaders(t *testing.T) {
+	req := httptest.NewRequest(vectorMethod, vectorURL, nil)
+	signer := &Signer{Keys: []Key{{ID: "k2", Secret: "other"}, {ID: "k1", Secret: vectorSecret}}}
+	signer.Sign(req, []byte(vectorBody), time.Unix(vectorTimestamp, 0))
+
+	if got := req.Header.Get(DefaultTimestampHeader); got != strconv.Itoa(vectorTimestamp) {
+		t.Fatalf("got timestamp %q", got)
+	}
+	want := "k2=" + Signature("other", vectorTimestamp, vectorMethod, vectorURL, []byte(vectorBody)) + ", k1=" + vectorSignature
+	if got := req.Header.Get(DefaultSignatureHeader); got != want {
+		t.Fatalf("got signature header %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	verifier := &Verifier{Keys: []Key{{ID: "k1", Secret: vectorSecret}}, BaseURL: "https://api.example.com"}
+
+	// signed returns a request received from the scheduler, signed at the given time
+	signed := func(at time.Time, sign func(signature string) string) *http.Request {
+		req := httptest.NewRequest(vectorMethod, "/hooks/billing?source=scheduler", strings.NewReader(vectorBody))
+		signature := Signature(vectorSecret, at.Unix(), vectorMethod, vectorURL, []byte(vectorBody))
+		req.Header.Set(DefaultTimestampHeader, strconv.FormatInt(at.Unix(), 10))
+		req.Header.Set(DefaultSignatureHeader, sign(signature))
+		return req
+	}
+	valid := func(signature string) string { return "k1=" + signature }
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want error
+	}{
+		{name: "valid signature", req: signed(now, valid)},
+		{
+			name: "valid signature among the signatures of unknown keys",
+			req:  signed(now, func(signature string) string { return "k9=abc, k1=" + signature }),
+		},
+		{name: "timestamp within the tolerance", req: signed(now.Add(-DefaultTolerance+time.Minute), valid)},
+		{name: "stale timestamp", req: signed(now.Add(-DefaultTolerance-time.Minute), valid), want: ErrStaleTimestamp},
+		{name: "timestamp in the future", req: signed(now.Add(DefaultTolerance+time.Minute), valid), want: ErrStaleTimestamp},
+		{
+			name: "signature differing by the last character",
+			req: signed(now, func(signature string) string {
+				last := "0"
+				if strings.HasSuffix(signature, "0") {
+					last = "1"
+				}
+				return "k1=" + signature[:len(signature)-1] + last
+			}),
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "truncated signature",
+			req:  signed(now, func(signature string) string { return "k1=" + signature[:32] }),
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "signature under another key id",
+			req:  signed(now, func(signature string) string { return "k2=" + signature }),
+			want: ErrInvalidSignature,
+		},
+		{name: "missing signature", req: signed(now, func(string) string { return "" }), want: ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifier.Verify(tt.req); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("body is restored for the handlers", func(t *testing.T) {
+		req := signed(now, valid)
+		if err := verifier.Verify(req); err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(req.Body)
+		if !bytes.Equal(body, []byte(vectorBody)) {
+			t.Fatalf("got body %q", body)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := signed(now, valid)
+		req.Body = io.NopCloser(strings.NewReader(`{"invoice":43}`))
+		if err := verifier.Verify(req); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("got %v, want %v", err, ErrInvalidSignature)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/maacarma/scheduler/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// supported exporters in the config file
+const (
+	OTLP   = "otlp"
+	STDOUT = "stdout"
+)
+
+const (
+	// name is the instrumentation name of the scheduler spans.
+	name        = "github.com/maacarma/scheduler"
+	unkExporter = "unknown tracing exporter: %s"
+)
+
+// Tracer returns the tracer of the scheduler spans,
+// the spans are dropped unless the tracing is set up.
+func Tracer() trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Setup sets up the tracing with the exporter of the config and the W3C trace-context propagation.
+// It returns the func flushing the pending spans and shutting the tracing down,
+// a no-op when the tracing is disabled.
+func Setup(ctx context.Context, conf *config.Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch conf.Tracing.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case OTLP:
+		opts := []otlptracehttp.Option{}
+		if conf.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(conf.Tracing.Endpoint))
+		}
+		if conf.Tracing.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf(unkExporter, conf.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(conf, exporter)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider batching the spans to the exporter,
+// an in-memory exporter (tracetest.NewInMemoryExporter) can be used in the tests.
+func NewProvider(conf *config.Config, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	sampler := sdktrace.AlwaysSample()
+	if ratio := conf.Tracing.SampleRatio; ratio > 0 && ratio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(ratio)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(conf.Application.Name),
+			semconv.ServiceInstanceID(conf.ReplicaID()),
+		)),
+	)
+}
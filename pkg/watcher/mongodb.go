@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// runStateFields are the fields updated by the scheduler on the runs of a task,
+// the updates of only these fields don't change the task definition.
+var runStateFields = map[string]bool{
+	"run_count":      true,
+	"last_fire_unix": true,
+	"completed":      true,
+}
+
+// event is the change event of a task.
+type event struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// changesDefinition checks if the event changes the task definition.
+func (e *event) changesDefinition() bool {
+	if e.OperationType != "update" || len(e.UpdateDescription.RemovedFields) > 0 {
+		return true
+	}
+
+	for field := range e.UpdateDescription.UpdatedFields {
+		if !runStateFields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// mongoStream is the stream of the task changes watched with a change stream.
+type mongoStream struct {
+	col *mongo.Collection
+}
+
+func newMongoStream(client *mongo.Client) *mongoStream {
+	return &mongoStream{col: client.Database("scheduler").Collection("tasks")}
+}
+
+// listen watches the change stream of the tasks collection,
+// the updates of only the run state of a task are skipped.
+func (s *mongoStream) listen(ctx context.Context, ready func(), handle func(change)) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		}}},
+	}
+	stream, err := s.col.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	ready()
+	for stream.Next(ctx) {
+		var e event
+		if err := stream.Decode(&e); err != nil {
+			return err
+		}
+		if !e.changesDefinition() {
+			continue
+		}
+
+		op := opUpsert
+		if e.OperationType == "delete" {
+			op = opDelete
+		}
+		handle(change{Op: op, TaskID: e.DocumentKey.ID.Hex()})
+	}
+
+	return stream.Err()
+}
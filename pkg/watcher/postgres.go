@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// channel is notified by the tasks_notify_change trigger of the schema,
+// on the inserts, deletes and the updates of the task definitions.
+const (
+	channel  = "task_changes"
+	listen   = "LISTEN " + channel
+	unlisten = "UNLISTEN " + channel
+)
+
+// pgStream is the stream of the task changes notified on the channel.
+type pgStream struct {
+	pool *pgxpool.Pool
+}
+
+func newPgStream(pool *pgxpool.Pool) *pgStream {
+	return &pgStream{pool: pool}
+}
+
+// listen holds a dedicated connection of the pool listening on the channel,
+// a notification with an unknown payload is ignored.
+func (s *pgStream) listen(ctx context.Context, ready func(), handle func(change)) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, listen); err != nil {
+		return err
+	}
+	// the connection goes back to the pool, it shouldn't be listening anymore
+	defer conn.Exec(context.Background(), unlisten)
+
+	ready()
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var c change
+		if err := json.Unmarshal([]byte(notification.Payload), &c); err != nil {
+			continue
+		}
+		handle(c)
+	}
+}
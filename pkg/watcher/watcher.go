@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maacarma/scheduler/config"
+	db "github.com/maacarma/scheduler/pkg/db"
+	encryption "github.com/maacarma/scheduler/pkg/encryption"
+	vErrors "github.com/maacarma/scheduler/pkg/errors"
+	models "github.com/maacarma/scheduler/pkg/services/tasks/models"
+	mongodb "github.com/maacarma/scheduler/pkg/services/tasks/store/mongodb"
+	postgres "github.com/maacarma/scheduler/pkg/services/tasks/store/postgres"
+
+	"go.uber.org/zap"
+)
+
+const (
+	watching         = "watching the task changes"
+	watchErr         = "unable to watch the task changes due to %v, retrying in %s"
+	unableToApply    = "unable to apply the change of task with id: %s due to %v"
+	unableToResync   = "unable to resync the scheduler due to %v"
+	opUpsert         = "upsert"
+	opDelete         = "delete"
+	defaultRetry     = time.Second
+	maxRetryInterval = time.Minute
+)
+
+// change is a change of a task in the database, the task is read again to apply it.
+type change struct {
+	Op     string `json:"op"`
+	TaskID string `json:"id"`
+}
+
+// stream is the stream of the task changes, backed by the configured database.
+type stream interface {
+	// listen calls ready once subscribed to the task changes and handle for each change,
+	// until the ctx is done or the subscription fails.
+	listen(ctx context.Context, ready func(), handle func(change)) error
+}
+
+// repo reads the changed tasks.
+type repo interface {
+	GetByID(ctx context.Context, id string) (*models.Task, error)
+}
+
+// Scheduler is the scheduler the task changes are applied to.
+type Scheduler interface {
+	ApplyTask(t *models.Task)
+	DiscardTaskNow(taskID string)
+	Resync(ctx context.Context) error
+}
+
+// Watcher applies the task changes made by the other replicas or directly in the database
+// to the local scheduler, using the postgres LISTEN/NOTIFY or the mongo change streams.
+// the scheduler is resynced with the database whenever the watch (re)starts,
+// as the changes made while not watching are lost.
+type Watcher struct {
+	stream stream
+	repo   repo
+	retry  time.Duration
+	logger *zap.Logger
+}
+
+// New creates a watcher of the task changes in the database of the clients.
+func New(ctx context.Context, dbClients *db.Clients, conf *config.Config, logger *zap.Logger) (*Watcher, error) {
+	retry := conf.Watcher.RetryInterval
+	if retry <= 0 {
+		retry = defaultRetry
+	}
+
+	keyring, err := encryption.FromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{retry: retry, logger: logger}
+	switch {
+	case dbClients.Pg != nil:
+		w.stream = newPgStream(dbClients.Pg)
+		w.repo = postgres.New(dbClients.Pg, keyring)
+	case dbClients.Mongo != nil:
+		w.stream = newMongoStream(dbClients.Mongo)
+		w.repo = mongodb.New(dbClients.Mongo, keyring)
+	}
+
+	return w, nil
+}
+
+// Run watches the task changes and applies them to the scheduler until the ctx is done.
+// the watch is retried after a failure, backing off up to a minute.
+func (w *Watcher) Run(ctx context.Context, scheduler Scheduler) {
+	retry := w.retry
+	for {
+		err := w.stream.listen(ctx, func() {
+			retry = w.retry
+			w.logger.Info(watching)
+			if err := scheduler.Resync(ctx); err != nil {
+				w.logger.Error(fmt.Sprintf(unableToResync, err))
+			}
+		}, func(c change) {
+			w.apply(ctx, scheduler, c)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		w.logger.Warn(fmt.Sprintf(watchErr, err, retry))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, maxRetryInterval)
+	}
+}
+
+// apply applies the change of the task to the scheduler,
+// the task is read again so that the latest state of the task is applied.
+func (w *Watcher) apply(ctx context.Context, scheduler Scheduler, c change) {
+	if c.Op == opDelete {
+		scheduler.DiscardTaskNow(c.TaskID)
+		return
+	}
+
+	task, err := w.repo.GetByID(ctx, c.TaskID)
+	if errors.Is(err, vErrors.ErrTaskNotFound) {
+		scheduler.DiscardTaskNow(c.TaskID)
+		return
+	}
+	if err != nil {
+		w.logger.Error(fmt.Sprintf(unableToApply, c.TaskID, err))
+		return
+	}
+
+	scheduler.ApplyTask(task)
+}
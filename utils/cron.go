@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronParser accepts standard 5 field cron specs, 6 field specs with a
+// leading seconds field and descriptors like @daily, @hourly.
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// ParseCron parses the given cron spec into a cron schedule.
+// Ex: "0 9 * * 1-5", "*/30 * * * * *", "@daily"
+func ParseCron(spec string) (cron.Schedule, error) {
+	return cronParser.Parse(spec)
+}
+
+// ParseCronIn parses the given cron spec into a schedule evaluated
+// against the wall clock of the given location.
+func ParseCronIn(spec string, loc *time.Location) (cron.Schedule, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	specSchedule, ok := schedule.(*cron.SpecSchedule)
+	if !ok {
+		// constant delay schedules (@every) doesn't depend on the location
+		return schedule, nil
+	}
+
+	// the spec is evaluated in UTC, which acts as a wall clock without DST
+	utcSpec := *specSchedule
+	utcSpec.Location = time.UTC
+	return &zonedSchedule{spec: &utcSpec, loc: loc}, nil
+}
+
+// Once returns a schedule that fires only at the given time.
+func Once(at time.Time) cron.Schedule {
+	return onceSchedule{at: at}
+}
+
+// onceSchedule is a cron schedule with a single fire time.
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next returns the fire time if it is after the given time, zero time otherwise.
+func (o onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(o.at) {
+		return o.at
+	}
+
+	return time.Time{}
+}
+
+// zonedSchedule is a cron schedule that matches the wall clock of a location.
+//
+// The specs firing every hour (Ex: "*/20 * * * *", "0 * * * *") follow the clock as it is,
+// like the standard cron does for such jobs. They keep firing through the hour repeated
+// by a DST overlap and the fire times skipped by a DST gap are left behind.
+//
+// The other specs fire at fixed times of the day. Their fire times that fall in a DST gap
+// (Ex: 02:30 when clocks jump from 02:00 to 03:00) run at the end of the gap instead of being
+// skipped for the day, and those repeated by a DST overlap (Ex: 01:30 when clocks fall back
+// from 02:00 to 01:00) run only once, at their first occurrence.
+type zonedSchedule struct {
+	spec *cron.SpecSchedule
+	loc  *time.Location
+}
+
+// everyHour is the hour field of a spec matching every hour of the day.
+const everyHour = 1<<24 - 1
+
+// Next returns the next fire time after the given time.
+func (z *zonedSchedule) Next(t time.Time) time.Time {
+	if z.spec.Hour&everyHour == everyHour {
+		return z.nextByClock(t)
+	}
+
+	wall := wallClock(t.In(z.loc))
+	for {
+		wall = z.spec.Next(wall)
+		if wall.IsZero() {
+			return wall
+		}
+
+		next := fromWallClock(wall, z.loc)
+		if next.After(t) {
+			return next.In(t.Location())
+		}
+	}
+}
+
+// nextByClock returns the next instant after the given time whose wall clock matches the spec.
+// The wall clock is read with the offset of each zone period in turn, so that
+// the hour repeated by a DST overlap is matched in both periods.
+func (z *zonedSchedule) nextByClock(t time.Time) time.Time {
+	at := t.In(z.loc)
+	wall := wallClock(at)
+	for {
+		_, offset := at.Zone()
+		_, end := at.ZoneBounds()
+
+		next := z.spec.Next(wall)
+		if next.IsZero() {
+			return next
+		}
+
+		instant := next.Add(-time.Duration(offset) * time.Second)
+		if end.IsZero() || instant.Before(end) {
+			return instant.In(t.Location())
+		}
+
+		// the fire time is past the zone period, matches again from the start of the next period
+		at = end.In(z.loc)
+		wall = wallClock(at).Add(-time.Nanosecond)
+	}
+}
+
+// wallClock returns the wall clock of the given time as a UTC time.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// fromWallClock returns the first instant in the location that shows the given wall clock.
+// if the wall clock is skipped by a DST gap, it returns the instant the gap ends.
+func fromWallClock(wall time.Time, loc *time.Location) time.Time {
+	guess := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+
+	// offsets a day before and after covers both sides of a DST transition
+	var first time.Time
+	for _, probe := range []time.Time{guess.AddDate(0, 0, -1), guess, guess.AddDate(0, 0, 1)} {
+		_, offset := probe.Zone()
+		candidate := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if wallClock(candidate).Equal(wall) && (first.IsZero() || candidate.Before(first)) {
+			first = candidate
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+
+	// the wall clock read with the offset before the gap lands after the gap,
+	// whose zone starts right at the end of the gap.
+	_, offset := guess.AddDate(0, 0, -1).Zone()
+	start, _ := wall.Add(-time.Duration(offset) * time.Second).In(loc).ZoneBounds()
+	return start
+}
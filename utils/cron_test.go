@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(value string) time.Time {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			// clocks fall back from 03:00 CEST to 02:00 CET at 01:00 UTC
+			name:  "sub-hourly spec fires through the repeated hour",
+			spec:  "*/20 * * * *",
+			after: utc("2026-10-24T23:50:00Z"),
+			want: []time.Time{
+				utc("2026-10-25T00:00:00Z"), utc("2026-10-25T00:20:00Z"), utc("2026-10-25T00:40:00Z"),
+				utc("2026-10-25T01:00:00Z"), utc("2026-10-25T01:20:00Z"), utc("2026-10-25T01:40:00Z"),
+				utc("2026-10-25T02:00:00Z"),
+			},
+		},
+		{
+			name:  "hourly spec fires every hour through the repeated hour",
+			spec:  "0 * * * *",
+			after: utc("2026-10-24T23:30:00Z"),
+			want:  []time.Time{utc("2026-10-25T00:00:00Z"), utc("2026-10-25T01:00:00Z"), utc("2026-10-25T02:00:00Z")},
+		},
+		{
+			name:  "fixed time in the repeated hour fires once",
+			spec:  "30 2 * * *",
+			after: utc("2026-10-24T12:00:00Z"),
+			want:  []time.Time{utc("2026-10-25T00:30:00Z"), utc("2026-10-26T01:30:00Z")},
+		},
+		{
+			// clocks spring forward from 02:00 CET to 03:00 CEST at 01:00 UTC
+			name:  "fixed time in the gap fires at the end of the gap",
+			spec:  "30 2 * * *",
+			after: utc("2026-03-28T12:00:00Z"),
+			want:  []time.Time{utc("2026-03-29T01:00:00Z"), utc("2026-03-30T00:30:00Z")},
+		},
+		{
+			name:  "sub-hourly spec skips the gap",
+			spec:  "*/20 * * * *",
+			after: utc("2026-03-29T00:30:00Z"),
+			want:  []time.Time{utc("2026-03-29T00:40:00Z"), utc("2026-03-29T01:00:00Z"), utc("2026-03-29T01:20:00Z")},
+		},
+		{
+			name:  "fixed time away from the transitions",
+			spec:  "0 9 * * *",
+			after: utc("2026-07-01T00:00:00Z"),
+			want:  []time.Time{utc("2026-07-01T07:00:00Z"), utc("2026-07-02T07:00:00Z")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCronIn(tt.spec, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := tt.after
+			for i, want := range tt.want {
+				next = schedule.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("fire time %d: got %s, want %s", i, next.UTC().Format(time.RFC3339), want.Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
@@ -24,10 +24,18 @@ func Contains[T comparable](s []T, v T) bool {
 	return false
 }
 
-// ConvertToCronInterval converts a string interval to a cron interval
-// Ex: 1h -> @every 1g
-func ConvertToCronInterval(interval string) string {
-	return fmt.Sprintf("@every %s", interval)
+// Keys returns the keys of the map
+func Keys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Ptr returns a pointer to the value
+func Ptr[T any](v T) *T {
+	return &v
 }
 
 // AppendQueryParams appends query params to the given url
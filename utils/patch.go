@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergePatch applies a JSON merge patch (RFC 7386) to the JSON document.
+// null values in the patch removes the keys, objects are merged recursively
+// and any other value replaces the value in the document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var d, p any
+	if err := unmarshalNumbers(doc, &d); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalNumbers(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(d, p))
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// unmarshalNumbers unmarshals the JSON keeping the numbers as json.Number,
+// so that large integers like unix times don't lose precision.
+func unmarshalNumbers(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}